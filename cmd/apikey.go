@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/repository"
+	authService "github.com/sebastianaldi17/simple-wallet-app/internal/service/auth"
+)
+
+// apiKey issues and revokes API keys:
+//
+//	apikey create -subject name [-scopes scope,...]
+//	apikey revoke -id id
+func apiKey(repository *repository.Repository, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: apikey create -subject name [-scopes scope,...] | apikey revoke -id id")
+		return 2
+	}
+
+	service := authService.NewService(repository, nil)
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		subject := flags.String("subject", "", "principal the key authenticates as; it owns the wallets it creates")
+		defaultScopes := make([]string, 0, len(entity.DefaultScopes))
+		for _, scope := range entity.DefaultScopes {
+			defaultScopes = append(defaultScopes, string(scope))
+		}
+		scopeList := flags.String("scopes", strings.Join(defaultScopes, ","), "comma-separated scopes of the key: wallet:read, wallet:write, ledger:admin")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+		var scopes []entity.Scope
+		for _, scope := range strings.Split(*scopeList, ",") {
+			scope = strings.TrimSpace(scope)
+			if !entity.ValidScope(entity.Scope(scope)) {
+				fmt.Fprintf(os.Stderr, "unknown scope %q, expected wallet:read, wallet:write or ledger:admin\n", scope)
+				return 2
+			}
+			scopes = append(scopes, entity.Scope(scope))
+		}
+
+		key, created, err := service.CreateAPIKey(*subject, scopes)
+		if err == entity.ErrInvalidSubject {
+			fmt.Fprintf(os.Stderr, "-subject is required, must be at most %d characters and must not start with %q\n", entity.MaxSubjectLength, entity.TokenSubjectPrefix)
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create API key: %v\n", err)
+			return 1
+		}
+		// The key is only stored hashed, this is the one chance to copy it
+		fmt.Printf("created API key %d for %q with scopes %s\n%s\n", created.ID, created.Subject, strings.Join(created.Scopes, ","), key)
+		return 0
+	case "revoke":
+		flags := flag.NewFlagSet("apikey revoke", flag.ContinueOnError)
+		id := flags.Int64("id", 0, "ID of the key to revoke")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		err := service.RevokeAPIKey(*id)
+		if err == entity.ErrAPIKeyNotFound {
+			fmt.Fprintf(os.Stderr, "API key %d not found or already revoked\n", *id)
+			return 1
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to revoke API key: %v\n", err)
+			return 1
+		}
+		fmt.Printf("revoked API key %d\n", *id)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown apikey command %q, expected create or revoke\n", args[0])
+		return 2
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/repository"
+	holdService "github.com/sebastianaldi17/simple-wallet-app/internal/service/hold"
+	transactionService "github.com/sebastianaldi17/simple-wallet-app/internal/service/transaction"
+)
+
+// expireHolds stores the expired status on the active holds past their expiry
+func expireHolds(repository *repository.Repository, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "expire-holds takes no arguments\n")
+		return 2
+	}
+
+	config := transactionConfig()
+	holds := holdService.NewService(repository, transactionService.NewService(repository, config), config.SystemAccounts)
+	count, err := holds.ExpireHolds()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "expiring holds failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("expired %d holds\n", count)
+	return 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/repository"
+	transactionService "github.com/sebastianaldi17/simple-wallet-app/internal/service/transaction"
+)
+
+// importTransactions applies a CSV file of deposits, withdrawals and transfers and prints the report as JSON.
+// It exits with 1 when a row was not applied.
+func importTransactions(repository *repository.Repository, args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	perRow := flags.Bool("per-row", false, "apply every row on its own instead of the whole file atomically")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import [-per-row] file.csv")
+		return 2
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open import file: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	service := transactionService.NewService(repository, transactionConfig())
+	report, err := service.ImportTransactions(entity.MaintenancePrincipal, file, !*perRow)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+		return 1
+	}
+
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
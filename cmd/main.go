@@ -1,18 +1,40 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/fx"
+	holdHandler "github.com/sebastianaldi17/simple-wallet-app/internal/handler/hold"
+	reconciliationHandler "github.com/sebastianaldi17/simple-wallet-app/internal/handler/reconciliation"
+	statementHandler "github.com/sebastianaldi17/simple-wallet-app/internal/handler/statement"
 	transactionHandler "github.com/sebastianaldi17/simple-wallet-app/internal/handler/transaction"
 	walletHandler "github.com/sebastianaldi17/simple-wallet-app/internal/handler/wallet"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/jwt"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/middleware"
 	"github.com/sebastianaldi17/simple-wallet-app/internal/repository"
+	authService "github.com/sebastianaldi17/simple-wallet-app/internal/service/auth"
+	holdService "github.com/sebastianaldi17/simple-wallet-app/internal/service/hold"
+	reconciliationService "github.com/sebastianaldi17/simple-wallet-app/internal/service/reconciliation"
+	statementService "github.com/sebastianaldi17/simple-wallet-app/internal/service/statement"
 	transactionService "github.com/sebastianaldi17/simple-wallet-app/internal/service/transaction"
 	walletService "github.com/sebastianaldi17/simple-wallet-app/internal/service/wallet"
+	"github.com/shopspring/decimal"
 )
 
+// main runs the HTTP server by default, or one of the maintenance commands:
+//
+//	main [serve]
+//	main reconcile [-repair]
+//	main snapshot [-at timestamp]
+//	main expire-holds
+//	main import [-per-row] file.csv
+//	main apikey create -subject name [-scopes scope,...]
+//	main apikey revoke -id id
 func main() {
 	connectionString := os.Getenv("DATABASE_URL")
 	if connectionString == "" {
@@ -22,28 +44,154 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	defer db.Close()
 
 	// Initialize repository
 	repository := repository.NewRepository(db)
 
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	exitCode := 0
+	switch command {
+	case "serve":
+		serve(repository)
+	case "reconcile":
+		exitCode = reconcile(repository, os.Args[2:])
+	case "snapshot":
+		exitCode = snapshot(repository, os.Args[2:])
+	case "expire-holds":
+		exitCode = expireHolds(repository, os.Args[2:])
+	case "import":
+		exitCode = importTransactions(repository, os.Args[2:])
+	case "apikey":
+		exitCode = apiKey(repository, os.Args[2:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected serve, reconcile, snapshot, expire-holds, import or apikey\n", command)
+		exitCode = 2
+	}
+
+	db.Close()
+	os.Exit(exitCode)
+}
+
+func serve(repository *repository.Repository) {
+	config := transactionConfig()
+
 	// Initialize services
-	transactionService := transactionService.NewService(repository)
+	authService := authService.NewService(repository, tokenVerifier())
+	transactionService := transactionService.NewService(repository, config)
 	walletService := walletService.NewService(repository)
+	holdService := holdService.NewService(repository, transactionService, config.SystemAccounts)
+	reconciliationService := reconciliationService.NewService(repository)
+	statementService := statementService.NewService(repository)
 
 	// Initialize handlers
 	transactionHandler := transactionHandler.NewHandler(transactionService)
 	walletHandler := walletHandler.NewHandler(walletService)
+	holdHandler := holdHandler.NewHandler(holdService)
+	reconciliationHandler := reconciliationHandler.NewHandler(reconciliationService)
+	statementHandler := statementHandler.NewHandler(statementService)
 
 	// Register routes
 	r := gin.Default()
+	r.Use(middleware.Authenticate(authService))
 
-	r.POST("/wallets", walletHandler.CreateWallet)
-	r.GET("/wallets/:id", walletHandler.GetBalance)
-	r.GET("/wallets/:id/transactions", walletHandler.GetTransactionHistory)
-	r.POST("/wallets/:id/transactions", transactionHandler.HandleNewTransaction)
+	// Every route group requires a scope; ledger:admin grants all of them
+	read := r.Group("", middleware.RequireScope(entity.ScopeWalletRead))
+	read.GET("/wallets", walletHandler.FindWallet)
+	read.GET("/wallets/:id", walletHandler.GetWallet)
+	read.GET("/wallets/:id/balance", walletHandler.GetBalanceAsOf)
+	read.GET("/wallets/:id/transactions", walletHandler.GetTransactionHistory)
+	read.GET("/wallets/:id/statements", statementHandler.GetStatement)
+	read.GET("/wallets/:id/limits", walletHandler.GetWalletLimits)
+	read.GET("/transactions/:id", transactionHandler.GetTransaction)
+	read.GET("/holds/:id", holdHandler.GetHold)
+	read.GET("/fees", transactionHandler.GetFeeSchedules)
+	read.GET("/fees/quote", transactionHandler.QuoteFee)
 
-	r.POST("/transfers", transactionHandler.HandleTransfer)
+	write := r.Group("", middleware.RequireScope(entity.ScopeWalletWrite))
+	write.POST("/wallets", walletHandler.CreateWallet)
+	write.PATCH("/wallets/:id", walletHandler.UpdateWallet)
+	write.POST("/wallets/:id/transactions", transactionHandler.HandleNewTransaction)
+	write.POST("/wallets/:id/holds", holdHandler.CreateHold)
+	write.POST("/transfers", transactionHandler.HandleTransfer)
+	write.POST("/transfers/batch", transactionHandler.HandleBatchTransfer)
+	write.POST("/transactions", transactionHandler.HandleMultiLegTransaction)
+	write.POST("/holds/:id/captures", holdHandler.CaptureHold)
+	write.POST("/holds/:id/void", holdHandler.VoidHold)
+
+	ledgerAdmin := r.Group("", middleware.RequireScope(entity.ScopeLedgerAdmin))
+	ledgerAdmin.POST("/transactions/:id/reversals", transactionHandler.HandleReversal)
+	ledgerAdmin.POST("/admin/reconciliations", reconciliationHandler.Reconcile)
+	ledgerAdmin.POST("/admin/wallets/:id/freeze", walletHandler.FreezeWallet)
+	ledgerAdmin.POST("/admin/wallets/:id/unfreeze", walletHandler.UnfreezeWallet)
+	ledgerAdmin.POST("/admin/wallets/:id/close", walletHandler.CloseWallet)
+	ledgerAdmin.PUT("/admin/wallets/:id/limits", walletHandler.SetWalletLimits)
+	ledgerAdmin.GET("/admin/limits/:currency", walletHandler.GetCurrencyLimits)
+	ledgerAdmin.PUT("/admin/limits/:currency", walletHandler.SetCurrencyLimits)
+	ledgerAdmin.PUT("/admin/fees/:transaction_type/:currency", transactionHandler.SetFeeSchedule)
+	ledgerAdmin.DELETE("/admin/fees/:transaction_type/:currency", transactionHandler.DeleteFeeSchedule)
+	ledgerAdmin.POST("/admin/imports", transactionHandler.ImportTransactions)
 
 	r.Run(":8080")
 }
+
+// tokenVerifier sets up bearer token authentication from the environment. It is enabled by JWT_JWKS,
+// the path or http(s) URL of the JSON Web Key Set of the token issuer; JWT_ISSUER and JWT_AUDIENCE
+// optionally pin the iss and aud claims. It returns nil, leaving only API keys, when JWT_JWKS is not set.
+func tokenVerifier() authService.TokenVerifier {
+	source := os.Getenv("JWT_JWKS")
+	if source == "" {
+		return nil
+	}
+	keys, err := jwt.LoadKeySet(source)
+	if err != nil {
+		panic(err)
+	}
+	return jwt.NewVerifier(keys, os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"))
+}
+
+// transactionConfig reads the FX and system account settings of the transaction service from the environment
+func transactionConfig() transactionService.Config {
+	var err error
+	rateProvider := fx.NewStaticRateProvider(nil)
+	if ratesFile := os.Getenv("FX_RATES_FILE"); ratesFile != "" {
+		rateProvider, err = fx.NewFileRateProvider(ratesFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	fxSpread := decimal.Zero
+	if spread := os.Getenv("FX_SPREAD"); spread != "" {
+		fxSpread, err = decimal.NewFromString(spread)
+		if err != nil {
+			panic(err)
+		}
+		// A spread of 1 or more would keep the whole converted amount, leaving the payee nothing or less
+		if fxSpread.IsNegative() || fxSpread.GreaterThanOrEqual(decimal.NewFromInt(1)) {
+			panic(fmt.Sprintf("FX_SPREAD must be at least 0 and less than 1, got %s", spread))
+		}
+	}
+
+	systemAccounts := entity.DefaultSystemAccounts
+	if code := os.Getenv("SYSTEM_ACCOUNT_CASH_IN"); code != "" {
+		systemAccounts.CashIn = code
+	}
+	if code := os.Getenv("SYSTEM_ACCOUNT_CASH_OUT"); code != "" {
+		systemAccounts.CashOut = code
+	}
+	if code := os.Getenv("SYSTEM_ACCOUNT_FX_HOUSE"); code != "" {
+		systemAccounts.FXHouse = code
+	}
+	if code := os.Getenv("SYSTEM_ACCOUNT_FEE_REVENUE"); code != "" {
+		systemAccounts.FeeRevenue = code
+	}
+
+	return transactionService.Config{
+		RateProvider:   rateProvider,
+		FXSpread:       fxSpread,
+		SystemAccounts: systemAccounts,
+	}
+}
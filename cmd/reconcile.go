@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/repository"
+	reconciliationService "github.com/sebastianaldi17/simple-wallet-app/internal/service/reconciliation"
+)
+
+// reconcile checks every balance against the ledger and prints the report as JSON.
+// It exits with 1 when the ledger does not balance, or without -repair when drift is found, so a cron job can alert on it.
+func reconcile(repository *repository.Repository, args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "reset drifted balances to their ledger balance")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	report, err := reconciliationService.NewService(repository).Reconcile(*repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reconciliation failed: %v\n", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+		return 1
+	}
+
+	// A repair leaves nothing to repair: each drift was either repaired or gone when re-checked under the lock.
+	// An imbalanced ledger is never repaired.
+	if len(report.Imbalances) > 0 || (!*repair && len(report.Drifts) > 0) {
+		return 1
+	}
+	return 0
+}
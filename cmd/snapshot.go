@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/repository"
+	walletService "github.com/sebastianaldi17/simple-wallet-app/internal/service/wallet"
+)
+
+// defaultSettleTime is how far in the past a snapshot must be taken unless -settle says otherwise.
+// Ledger legs are stamped with the start time of their database transaction, so a transaction still
+// in flight can commit legs dated before now; they would be missed by a snapshot taken at now.
+// A snapshot is only complete when no booking transaction runs longer than the settle time.
+const defaultSettleTime = time.Hour
+
+// snapshot records the balance of every account at the given instant, by default the last UTC midnight
+func snapshot(repository *repository.Repository, args []string) int {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	at := flags.String("at", "", "RFC 3339 timestamp of the snapshot (default: last UTC midnight)")
+	settle := flags.Duration("settle", defaultSettleTime, "how far in the past the snapshot must be, longer than any booking transaction runs")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	snapshotAt := time.Now().UTC().Truncate(24 * time.Hour)
+	if *at != "" {
+		var err error
+		snapshotAt, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -at timestamp: %v\n", err)
+			return 2
+		}
+	}
+	if *settle <= 0 {
+		fmt.Fprintf(os.Stderr, "-settle must be positive\n")
+		return 2
+	}
+	if snapshotAt.After(time.Now().Add(-*settle)) {
+		fmt.Fprintf(os.Stderr, "snapshot time must be at least %s in the past\n", *settle)
+		return 2
+	}
+
+	count, err := walletService.NewService(repository).CreateBalanceSnapshots(snapshotAt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snapshot failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("recorded %d balance snapshots at %s\n", count, snapshotAt.Format(time.RFC3339))
+	return 0
+}
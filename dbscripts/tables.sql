@@ -1,15 +1,46 @@
 CREATE TABLE accounts(
   id SERIAL PRIMARY KEY,
   name VARCHAR(100) NOT NULL,
+  currency CHAR(3) NOT NULL,
+  system_code VARCHAR(50),
+  status VARCHAR(20) NOT NULL DEFAULT 'active',
+  block_credits BOOLEAN NOT NULL DEFAULT FALSE,
+  external_ref VARCHAR(100),
+  owner VARCHAR(255),
+  metadata JSONB NOT NULL DEFAULT '{}',
   created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT unique_system_account UNIQUE (system_code, currency),
+  CONSTRAINT unique_external_ref UNIQUE (external_ref)
+);
+CREATE TABLE account_status_events(
+  id SERIAL PRIMARY KEY,
+  account_id INT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+  from_status VARCHAR(20) NOT NULL,
+  to_status VARCHAR(20) NOT NULL,
+  block_credits BOOLEAN NOT NULL,
+  reason TEXT NOT NULL,
+  changed_by VARCHAR(255) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 CREATE TABLE transactions(
   id SERIAL PRIMARY KEY,
+  transaction_type VARCHAR(20) NOT NULL,
   transaction_date TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
   description VARCHAR(255),
+  amount NUMERIC(38, 18) NOT NULL,
+  currency CHAR(3) NOT NULL,
+  fx_rate NUMERIC(38, 18),
+  reversal_of INT REFERENCES transactions(id),
+  fee_amount NUMERIC(38, 18) NOT NULL DEFAULT 0,
+  created_by VARCHAR(255) NOT NULL,
+  idempotency_key VARCHAR(255),
+  request_hash CHAR(64),
+  response_status INT,
+  response_body JSONB,
   created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT unique_idempotency_key UNIQUE (idempotency_key)
 );
 CREATE TABLE ledgers(
   id SERIAL PRIMARY KEY,
@@ -17,6 +48,7 @@ CREATE TABLE ledgers(
   account_id INT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
   amount NUMERIC(38, 18) NOT NULL,
   is_credit BOOLEAN NOT NULL DEFAULT FALSE,
+  balance_after NUMERIC(38, 18),
   created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
   updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
   CONSTRAINT check_amount_positive CHECK (amount > 0),
@@ -29,7 +61,69 @@ CREATE TABLE denormalized_balances(
   created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
   updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
+CREATE TABLE balance_snapshots(
+  id SERIAL PRIMARY KEY,
+  account_id INT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+  balance NUMERIC(38, 18) NOT NULL,
+  snapshot_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT unique_account_snapshot UNIQUE (account_id, snapshot_at)
+);
+CREATE TABLE holds(
+  id SERIAL PRIMARY KEY,
+  account_id INT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+  amount NUMERIC(38, 18) NOT NULL,
+  captured_amount NUMERIC(38, 18),
+  status VARCHAR(20) NOT NULL DEFAULT 'active',
+  description VARCHAR(255),
+  expires_at TIMESTAMPTZ NOT NULL,
+  transaction_id INT REFERENCES transactions(id),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT check_hold_amount_positive CHECK (amount > 0)
+);
+CREATE TABLE api_keys(
+  id SERIAL PRIMARY KEY,
+  key_prefix VARCHAR(20) NOT NULL,
+  key_hash CHAR(64) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  scopes TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at TIMESTAMPTZ,
+  CONSTRAINT unique_api_key_hash UNIQUE (key_hash)
+);
+CREATE TABLE transaction_limits(
+  id SERIAL PRIMARY KEY,
+  account_id INT REFERENCES accounts(id) ON DELETE CASCADE,
+  currency CHAR(3),
+  per_transaction NUMERIC(38, 18),
+  daily NUMERIC(38, 18),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT check_limit_scope CHECK ((account_id IS NULL) <> (currency IS NULL)),
+  CONSTRAINT check_limits_positive CHECK (per_transaction > 0 AND daily > 0),
+  CONSTRAINT unique_account_limits UNIQUE (account_id),
+  CONSTRAINT unique_currency_limits UNIQUE (currency)
+);
+CREATE TABLE fee_schedules(
+  id SERIAL PRIMARY KEY,
+  transaction_type VARCHAR(20) NOT NULL,
+  currency CHAR(3) NOT NULL,
+  fee_type VARCHAR(20) NOT NULL,
+  flat NUMERIC(38, 18) NOT NULL DEFAULT 0,
+  rate NUMERIC(38, 18) NOT NULL DEFAULT 0,
+  tiers JSONB NOT NULL DEFAULT '[]',
+  min_fee NUMERIC(38, 18),
+  max_fee NUMERIC(38, 18),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT unique_fee_schedule UNIQUE (transaction_type, currency)
+);
 CREATE INDEX idx_ledgers_transaction_id ON ledgers(transaction_id);
 CREATE INDEX idx_ledgers_account_id ON ledgers(account_id);
 CREATE INDEX idx_transactions_date ON transactions(transaction_date);
-CREATE INDEX idx_ledgers_account_date ON ledgers(account_id, created_at);
\ No newline at end of file
+CREATE INDEX idx_transactions_reversal_of ON transactions(reversal_of);
+CREATE INDEX idx_ledgers_account_date ON ledgers(account_id, created_at);
+CREATE INDEX idx_holds_account_status ON holds(account_id, status);
+CREATE INDEX idx_account_status_events_account_id ON account_status_events(account_id);
+CREATE INDEX idx_accounts_owner ON accounts(owner);
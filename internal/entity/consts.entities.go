@@ -1,15 +1,323 @@
 package entity
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
 
 type TransactionType string
 
 const (
 	TransactionTypeDeposit    TransactionType = "deposit"
 	TransactionTypeWithdrawal TransactionType = "withdrawal"
+	TransactionTypeTransfer   TransactionType = "transfer"
+	TransactionTypeReversal   TransactionType = "reversal"
+)
+
+// AccountStatus is the lifecycle state of an account.
+// Frozen accounts cannot pay out, and cannot receive either when credits are blocked; closed accounts are final.
+type AccountStatus string
+
+const (
+	AccountStatusActive AccountStatus = "active"
+	AccountStatusFrozen AccountStatus = "frozen"
+	AccountStatusClosed AccountStatus = "closed"
+)
+
+// TransactionStatus tells whether a transaction still stands or has been reversed
+type TransactionStatus string
+
+const (
+	TransactionStatusPosted            TransactionStatus = "posted"
+	TransactionStatusPartiallyReversed TransactionStatus = "partially_reversed"
+	TransactionStatusReversed          TransactionStatus = "reversed"
+)
+
+// EntryType describes a transaction from the point of view of one of its accounts
+type EntryType string
+
+const (
+	EntryTypeDeposit     EntryType = "deposit"
+	EntryTypeWithdrawal  EntryType = "withdrawal"
+	EntryTypeTransferIn  EntryType = "transfer_in"
+	EntryTypeTransferOut EntryType = "transfer_out"
+	EntryTypeReversal    EntryType = "reversal"
+)
+
+// EntryTypeOf derives the entry type of a ledger leg from its transaction type and direction
+func EntryTypeOf(transactionType TransactionType, isCredit bool) EntryType {
+	switch transactionType {
+	case TransactionTypeDeposit:
+		return EntryTypeDeposit
+	case TransactionTypeWithdrawal:
+		return EntryTypeWithdrawal
+	case TransactionTypeReversal:
+		return EntryTypeReversal
+	}
+	if isCredit {
+		return EntryTypeTransferOut
+	}
+	return EntryTypeTransferIn
+}
+
+type StatementFormat string
+
+const (
+	StatementFormatCSV   StatementFormat = "csv"
+	StatementFormatJSONL StatementFormat = "jsonl"
+	StatementFormatText  StatementFormat = "txt"
+)
+
+type HoldStatus string
+
+const (
+	HoldStatusActive   HoldStatus = "active"
+	HoldStatusCaptured HoldStatus = "captured"
+	HoldStatusVoided   HoldStatus = "voided"
+	HoldStatusExpired  HoldStatus = "expired"
+)
+
+// FeeType tells how a fee schedule computes its fee
+type FeeType string
+
+const (
+	// FeeTypeFlat charges the same fee on every amount
+	FeeTypeFlat FeeType = "flat"
+	// FeeTypePercentage charges a fraction of the amount
+	FeeTypePercentage FeeType = "percentage"
+	// FeeTypeTiered charges the flat fee plus the fraction of the tier the whole amount falls in
+	FeeTypeTiered FeeType = "tiered"
+)
+
+// SystemAccounts holds the codes of the house accounts the ledger books against.
+// Each code identifies one system account per currency, created on first use.
+type SystemAccounts struct {
+	// CashIn is the counterparty of deposits
+	CashIn string
+	// CashOut is the counterparty of withdrawals
+	CashOut string
+	// FXHouse holds the house position of cross-currency transfers
+	FXHouse string
+	// FeeRevenue collects the fees charged on withdrawals and transfers
+	FeeRevenue string
+}
+
+var DefaultSystemAccounts = SystemAccounts{
+	CashIn:     "cash_in_clearing",
+	CashOut:    "cash_out_clearing",
+	FXHouse:    "fx_house",
+	FeeRevenue: "fee_revenue",
+}
+
+// Scope is a permission granted to an API key or bearer token
+type Scope string
+
+const (
+	// ScopeWalletRead allows reading wallets, their history and statements, transactions and holds
+	ScopeWalletRead Scope = "wallet:read"
+	// ScopeWalletWrite allows creating and updating wallets and moving funds out of them
+	ScopeWalletWrite Scope = "wallet:write"
+	// ScopeLedgerAdmin allows the admin operations, like freezes, reconciliations and reversals.
+	// It grants every other scope and access to every account.
+	ScopeLedgerAdmin Scope = "ledger:admin"
+)
+
+// DefaultScopes are the scopes of API keys issued without explicit scopes
+var DefaultScopes = []Scope{ScopeWalletRead, ScopeWalletWrite}
+
+// ValidScope tells whether scope is one of the scopes known to the service
+func ValidScope(scope Scope) bool {
+	switch scope {
+	case ScopeWalletRead, ScopeWalletWrite, ScopeLedgerAdmin:
+		return true
+	}
+	return false
+}
+
+// MaintenancePrincipal is the principal of the maintenance commands run from the command line
+var MaintenancePrincipal = Principal{
+	Subject: "maintenance",
+	Scopes:  []Scope{ScopeLedgerAdmin},
+}
+
+const (
+	// MaxSubjectLength bounds the subject of a principal, which is stored as the owner of its wallets
+	MaxSubjectLength = 255
+	// TokenSubjectPrefix namespaces the subjects of bearer tokens, so the sub claim of a token cannot name the principal of an API key
+	TokenSubjectPrefix = "jwt:"
+)
+
+// MaxBatchTransfers bounds the number of transfers of a batch transfer request
+const MaxBatchTransfers = 1000
+
+// currencyScales maps the supported ISO 4217 currency codes to the number of digits of their minor unit
+var currencyScales = map[string]int32{
+	"AUD": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"IDR": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"MYR": 2,
+	"SGD": 2,
+	"USD": 2,
+}
+
+// ImportRowStatus is the outcome of one row of a bulk import
+type ImportRowStatus string
+
+const (
+	ImportRowStatusSuccess           ImportRowStatus = "success"
+	ImportRowStatusInvalid           ImportRowStatus = "invalid"
+	ImportRowStatusUnknownAccount    ImportRowStatus = "unknown_account"
+	ImportRowStatusInsufficientFunds ImportRowStatus = "insufficient_funds"
+	ImportRowStatusRejected          ImportRowStatus = "rejected"
+	ImportRowStatusFailed            ImportRowStatus = "failed"
+	// ImportRowStatusNotApplied marks the rows of an atomic import that was rolled back because of another row
+	ImportRowStatusNotApplied ImportRowStatus = "not_applied"
 )
 
 var (
 	ErrAccountNotFound   = errors.New("account not found")
 	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrSystemAccount     = errors.New("system accounts cannot be used directly")
+
+	ErrDuplicateExternalRef = errors.New("external reference already used by another account")
+
+	ErrInvalidAPIKey     = errors.New("invalid API key")
+	ErrAPIKeyNotFound    = errors.New("API key not found")
+	ErrInvalidToken      = errors.New("invalid bearer token")
+	ErrInvalidSubject    = errors.New("invalid subject")
+	ErrTokenAuthDisabled = errors.New("bearer tokens are not accepted")
+	ErrForbidden         = errors.New("access to the account is not allowed")
+
+	ErrAccountFrozen           = errors.New("account is frozen")
+	ErrAccountClosed           = errors.New("account is closed")
+	ErrInvalidStatusTransition = errors.New("account status transition not allowed")
+	ErrAccountNotEmpty         = errors.New("account balance must be zero without active holds to close it")
+
+	ErrTooFewLedgerLegs      = errors.New("a transaction needs at least two legs")
+	ErrNonPositiveLedgerLeg  = errors.New("ledger leg amounts must be positive")
+	ErrDuplicateLedgerLeg    = errors.New("an account can only appear in one leg of a transaction")
+	ErrUnbalancedTransaction = errors.New("debit and credit legs do not balance")
+
+	ErrDuplicateIdempotencyKey = errors.New("idempotency key already used")
+
+	ErrAmountNotPositive      = errors.New("amount must be greater than zero")
+	ErrDescriptionRequired    = errors.New("description is required")
+	ErrDescriptionTooLong     = errors.New("description must be less than 100 characters")
+	ErrSameAccountTransfer    = errors.New("cannot transfer to the same account")
+	ErrInvalidBatchSize       = errors.New("a batch must have between 1 and 1000 transfers")
+	ErrInvalidTransactionType = errors.New("invalid transaction type")
+	ErrInvalidImportFile      = errors.New("invalid import file")
+
+	ErrUnsupportedCurrency     = errors.New("unsupported currency")
+	ErrInvalidAmountScale      = errors.New("amount has more decimal places than the currency allows")
+	ErrCurrencyMismatch        = errors.New("currency does not match the account currency")
+	ErrExchangeRateUnavailable = errors.New("exchange rate unavailable")
+	ErrConversionTooSmall      = errors.New("converted amount rounds to zero")
+
+	ErrTransactionNotFound        = errors.New("transaction not found")
+	ErrCannotReverseReversal      = errors.New("a reversal cannot be reversed")
+	ErrReversalExceedsTransaction = errors.New("reversal amount exceeds the remaining amount of the transaction")
+
+	ErrHoldNotFound       = errors.New("hold not found")
+	ErrHoldNotActive      = errors.New("hold is no longer active")
+	ErrCaptureExceedsHold = errors.New("capture amount exceeds the held amount")
+
+	ErrLimitExceeded = errors.New("transaction limit exceeded")
+
+	ErrFeeScheduleNotFound = errors.New("fee schedule not found")
+	ErrInvalidFeeSchedule  = errors.New("invalid fee schedule")
 )
+
+// LimitType names the transaction limit a payout exceeded
+type LimitType string
+
+const (
+	LimitTypePerTransaction LimitType = "per_transaction"
+	LimitTypeDaily          LimitType = "daily"
+)
+
+// LimitExceededError reports a payout breaking a transaction limit of its account.
+// It matches ErrLimitExceeded with errors.Is.
+type LimitExceededError struct {
+	Limit    LimitType       `json:"limit"`
+	Amount   decimal.Decimal `json:"limit_amount"`
+	Currency string          `json:"currency"`
+	// Remaining is the largest amount the account can still pay out right now
+	Remaining decimal.Decimal `json:"remaining"`
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s limit of %s %s exceeded, %s remaining", e.Limit, e.Amount, e.Currency, e.Remaining)
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// CurrencyScale returns the number of decimal places allowed for amounts in the given currency
+func CurrencyScale(currency string) (int32, bool) {
+	scale, ok := currencyScales[currency]
+	return scale, ok
+}
+
+// ValidateCurrencyAmount checks that the currency is supported and the amount fits its minor unit
+func ValidateCurrencyAmount(currency string, amount decimal.Decimal) error {
+	scale, ok := CurrencyScale(currency)
+	if !ok {
+		return ErrUnsupportedCurrency
+	}
+	if !amount.Equal(amount.Truncate(scale)) {
+		return ErrInvalidAmountScale
+	}
+	return nil
+}
+
+// validateDescription checks the description every transaction request must carry
+func validateDescription(description string) error {
+	if description == "" {
+		return ErrDescriptionRequired
+	}
+	if len(description) > 100 {
+		return ErrDescriptionTooLong
+	}
+	return nil
+}
+
+// ValidateLedgerLegs checks that a transaction has at least two positive legs on distinct accounts
+// and that, for every currency, its credit legs sum up to its debit legs
+func ValidateLedgerLegs(legs []LedgerLeg) error {
+	if len(legs) < 2 {
+		return ErrTooFewLedgerLegs
+	}
+
+	accounts := make(map[int64]bool, len(legs))
+	totals := make(map[string]decimal.Decimal)
+	for _, leg := range legs {
+		if !leg.Amount.IsPositive() {
+			return ErrNonPositiveLedgerLeg
+		}
+		if accounts[leg.AccountID] {
+			return ErrDuplicateLedgerLeg
+		}
+		accounts[leg.AccountID] = true
+
+		if leg.IsCredit {
+			totals[leg.Currency] = totals[leg.Currency].Add(leg.Amount)
+		} else {
+			totals[leg.Currency] = totals[leg.Currency].Sub(leg.Amount)
+		}
+	}
+
+	for _, total := range totals {
+		if !total.IsZero() {
+			return ErrUnbalancedTransaction
+		}
+	}
+	return nil
+}
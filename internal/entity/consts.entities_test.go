@@ -0,0 +1,30 @@
+package entity
+
+import "testing"
+
+func TestValidateLedgerLegs(t *testing.T) {
+	leg := func(accountID int64, amount string, isCredit bool) LedgerLeg {
+		return LedgerLeg{AccountID: accountID, Amount: d(amount), Currency: "USD", IsCredit: isCredit}
+	}
+	tests := []struct {
+		name string
+		legs []LedgerLeg
+		want error
+	}{
+		{"balanced", []LedgerLeg{leg(1, "10", true), leg(2, "4", false), leg(3, "6", false)}, nil},
+		{"no legs", nil, ErrTooFewLedgerLegs},
+		{"single leg", []LedgerLeg{leg(1, "10", true)}, ErrTooFewLedgerLegs},
+		{"zero amount", []LedgerLeg{leg(1, "0", true), leg(2, "0", false)}, ErrNonPositiveLedgerLeg},
+		{"negative amount", []LedgerLeg{leg(1, "10", true), leg(2, "-10", false)}, ErrNonPositiveLedgerLeg},
+		{"repeated account", []LedgerLeg{leg(1, "10", true), leg(1, "10", false)}, ErrDuplicateLedgerLeg},
+		{"unbalanced", []LedgerLeg{leg(1, "10", true), leg(2, "9", false)}, ErrUnbalancedTransaction},
+		{"unbalanced in another currency", []LedgerLeg{leg(1, "10", true), leg(2, "10", false), {AccountID: 3, Amount: d("10"), Currency: "EUR", IsCredit: false}}, ErrUnbalancedTransaction},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateLedgerLegs(test.legs); err != test.want {
+				t.Errorf("ValidateLedgerLegs() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,15 @@
 package entity
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
 
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 )
 
@@ -16,5 +23,395 @@ type TransactionDetail struct {
 	LedgerID  int             `json:"ledger_id" db:"ledger_id"`
 	AccountID int             `json:"account_id" db:"account_id"`
 	Amount    decimal.Decimal `json:"amount" db:"amount"`
+	Currency  string          `json:"currency" db:"currency"`
 	IsCredit  bool            `json:"is_credit" db:"is_credit"`
+	// BalanceAfter is the account balance right after this leg was booked, null on system accounts
+	BalanceAfter decimal.NullDecimal `json:"balance_after" db:"balance_after"`
+	// Fee is the part of the amount of a payer's leg charged as a fee, or refunded to the payer by a reversal
+	Fee decimal.Decimal `json:"fee" db:"fee_amount"`
+
+	// Derived fields
+	TransactionType TransactionType `json:"-" db:"transaction_type"`
+	Type            EntryType       `json:"type" db:"-"`
+	Counterparties  []Counterparty  `json:"counterparties" db:"-"`
+}
+
+// Counterparty is a customer account on another leg of a transaction.
+// System accounts are not reported as counterparties.
+type Counterparty struct {
+	TransactionID int    `json:"-" db:"transaction_id"`
+	AccountID     int64  `json:"account_id" db:"account_id"`
+	AccountName   string `json:"account_name" db:"name"`
+}
+
+// Transaction represents a row of the transactions table.
+// Amount and Currency hold the principal of the transaction, e.g. the source amount of a transfer.
+type Transaction struct {
+	ID              int64               `db:"id"`
+	Type            TransactionType     `db:"transaction_type"`
+	TransactionDate time.Time           `db:"transaction_date"`
+	Description     string              `db:"description"`
+	Amount          decimal.Decimal     `db:"amount"`
+	Currency        string              `db:"currency"`
+	FXRate          decimal.NullDecimal `db:"fx_rate"`
+	ReversalOf      sql.NullInt64       `db:"reversal_of"`
+	// Fee is charged to the payer on top of Amount, in the same currency. On a reversal it is the part of the fee refunded.
+	Fee decimal.Decimal `db:"fee_amount"`
+	// CreatedBy is the subject of the principal that booked the transaction, kept for audit
+	CreatedBy string `db:"created_by"`
+}
+
+// Ledger represents a ledger leg along with the currency and system code of its account
+type Ledger struct {
+	ID            int64               `db:"id"`
+	TransactionID int64               `db:"transaction_id"`
+	AccountID     int64               `db:"account_id"`
+	Amount        decimal.Decimal     `db:"amount"`
+	IsCredit      bool                `db:"is_credit"`
+	BalanceAfter  decimal.NullDecimal `db:"balance_after"`
+	Currency      string              `db:"currency"`
+	SystemCode    sql.NullString      `db:"system_code"`
+	Owner         sql.NullString      `db:"owner"`
+}
+
+// LedgerLeg describes a ledger leg to be written for a new transaction
+type LedgerLeg struct {
+	AccountID int64
+	Amount    decimal.Decimal
+	Currency  string
+	IsCredit  bool
+}
+
+// AccountBalance represents the denormalized balance of an account along with its currency.
+// HeldAmount is the sum of the account's active holds, which are not part of the ledger balance.
+type AccountBalance struct {
+	AccountID    int64           `db:"account_id"`
+	Currency     string          `db:"currency"`
+	SystemCode   sql.NullString  `db:"system_code"`
+	Owner        sql.NullString  `db:"owner"`
+	Status       AccountStatus   `db:"status"`
+	BlockCredits bool            `db:"block_credits"`
+	Balance      decimal.Decimal `db:"balance"`
+	HeldAmount   decimal.Decimal `db:"held_amount"`
+}
+
+// Account represents the profile of an account along with its balance.
+// ExternalRef is the optional, unique reference of the account in an external system.
+type Account struct {
+	AccountBalance
+	Name        string         `db:"name"`
+	ExternalRef sql.NullString `db:"external_ref"`
+	Metadata    types.JSONText `db:"metadata"`
+	CreatedAt   time.Time      `db:"created_at"`
+	UpdatedAt   time.Time      `db:"updated_at"`
+}
+
+// CheckPayOut returns an error when the status of the account forbids taking money out of it
+func (b AccountBalance) CheckPayOut() error {
+	switch b.Status {
+	case AccountStatusClosed:
+		return ErrAccountClosed
+	case AccountStatusFrozen:
+		return ErrAccountFrozen
+	}
+	return nil
+}
+
+// CheckPayIn returns an error when the status of the account forbids paying money into it
+func (b AccountBalance) CheckPayIn() error {
+	switch {
+	case b.Status == AccountStatusClosed:
+		return ErrAccountClosed
+	case b.Status == AccountStatusFrozen && b.BlockCredits:
+		return ErrAccountFrozen
+	}
+	return nil
+}
+
+// CheckLeg returns an error when the status of the account forbids booking a leg in the given direction
+func (b AccountBalance) CheckLeg(isCredit bool) error {
+	if isCredit {
+		return b.CheckPayOut()
+	}
+	return b.CheckPayIn()
+}
+
+// Available returns the balance that can be spent, i.e. the ledger balance minus active holds
+func (b AccountBalance) Available() decimal.Decimal {
+	return b.Balance.Sub(b.HeldAmount)
+}
+
+// TransactionLimits caps what an account pays out through withdrawals and outgoing transfers.
+// A limit that is not set does not cap anything.
+type TransactionLimits struct {
+	PerTransaction decimal.NullDecimal `json:"per_transaction" db:"per_transaction"`
+	// Daily caps the total paid out over a rolling 24 hours
+	Daily decimal.NullDecimal `json:"daily" db:"daily"`
+}
+
+// Allowance returns the largest single amount that can still be paid out after used was paid out
+// over the last 24 hours; it is not valid when no limit is set
+func (l TransactionLimits) Allowance(used decimal.Decimal) decimal.NullDecimal {
+	allowance := l.PerTransaction
+	if l.Daily.Valid {
+		remaining := decimal.Max(l.Daily.Decimal.Sub(used), decimal.Zero)
+		if !allowance.Valid || remaining.LessThan(allowance.Decimal) {
+			allowance = decimal.NewNullDecimal(remaining)
+		}
+	}
+	return allowance
+}
+
+// CheckPayOut returns a *LimitExceededError when paying amount out, after used was paid out over the last 24 hours, breaks a limit
+func (l TransactionLimits) CheckPayOut(currency string, amount, used decimal.Decimal) error {
+	exceeded := &LimitExceededError{Currency: currency, Remaining: l.Allowance(used).Decimal}
+	switch {
+	case l.PerTransaction.Valid && amount.GreaterThan(l.PerTransaction.Decimal):
+		exceeded.Limit, exceeded.Amount = LimitTypePerTransaction, l.PerTransaction.Decimal
+	case l.Daily.Valid && used.Add(amount).GreaterThan(l.Daily.Decimal):
+		exceeded.Limit, exceeded.Amount = LimitTypeDaily, l.Daily.Decimal
+	default:
+		return nil
+	}
+	return exceeded
+}
+
+// FeeSchedule is the fee charged on the transactions of one type and currency
+type FeeSchedule struct {
+	TransactionType TransactionType `json:"transaction_type" db:"transaction_type"`
+	Currency        string          `json:"currency" db:"currency"`
+	FeeType         FeeType         `json:"fee_type" db:"fee_type"`
+	// Flat is the fee of flat schedules
+	Flat decimal.Decimal `json:"flat" db:"flat"`
+	// Rate is the fraction of the amount charged by percentage schedules, e.g. 0.01 for 1%
+	Rate  decimal.Decimal `json:"rate" db:"rate"`
+	Tiers FeeTiers        `json:"tiers" db:"tiers"`
+	// MinFee and MaxFee bound the computed fee
+	MinFee    decimal.NullDecimal `json:"min_fee" db:"min_fee"`
+	MaxFee    decimal.NullDecimal `json:"max_fee" db:"max_fee"`
+	UpdatedAt time.Time           `json:"updated_at" db:"updated_at"`
+}
+
+// FeeTier is a band of a tiered fee schedule. The first tier with UpTo at least the amount applies to the whole amount;
+// the last tier has no UpTo and applies to any larger amount.
+type FeeTier struct {
+	UpTo decimal.NullDecimal `json:"up_to"`
+	Flat decimal.Decimal     `json:"flat"`
+	Rate decimal.Decimal     `json:"rate"`
+}
+
+// FeeTiers are the tiers of a tiered fee schedule in ascending order, stored as JSON
+type FeeTiers []FeeTier
+
+func (t FeeTiers) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	tiers, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(tiers), nil
+}
+
+func (t *FeeTiers) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, t)
+	case string:
+		return json.Unmarshal([]byte(src), t)
+	case nil:
+		*t = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into FeeTiers", src)
+}
+
+// Fee computes the fee on amount, bounded by the minimum and maximum of the schedule
+// and rounded to the minor unit of the currency
+func (f FeeSchedule) Fee(amount decimal.Decimal) decimal.Decimal {
+	var fee decimal.Decimal
+	switch f.FeeType {
+	case FeeTypeFlat:
+		fee = f.Flat
+	case FeeTypePercentage:
+		fee = amount.Mul(f.Rate)
+	case FeeTypeTiered:
+		for _, tier := range f.Tiers {
+			if !tier.UpTo.Valid || amount.LessThanOrEqual(tier.UpTo.Decimal) {
+				fee = tier.Flat.Add(amount.Mul(tier.Rate))
+				break
+			}
+		}
+	}
+	if f.MinFee.Valid {
+		fee = decimal.Max(fee, f.MinFee.Decimal)
+	}
+	if f.MaxFee.Valid {
+		fee = decimal.Min(fee, f.MaxFee.Decimal)
+	}
+	scale, _ := CurrencyScale(f.Currency)
+	return fee.Round(scale)
+}
+
+// Validate checks that the schedule has what its fee type needs, with amounts fitting the minor unit of the currency
+func (f FeeSchedule) Validate() error {
+	if f.TransactionType != TransactionTypeWithdrawal && f.TransactionType != TransactionTypeTransfer {
+		return ErrInvalidTransactionType
+	}
+	amounts := []decimal.Decimal{f.Flat}
+	rates := []decimal.Decimal{f.Rate}
+	switch f.FeeType {
+	case FeeTypeFlat:
+		if !f.Flat.IsPositive() {
+			return ErrInvalidFeeSchedule
+		}
+	case FeeTypePercentage:
+		if !f.Rate.IsPositive() {
+			return ErrInvalidFeeSchedule
+		}
+	case FeeTypeTiered:
+		if len(f.Tiers) == 0 {
+			return ErrInvalidFeeSchedule
+		}
+		for i, tier := range f.Tiers {
+			last := i == len(f.Tiers)-1
+			switch {
+			case tier.UpTo.Valid == last:
+				return ErrInvalidFeeSchedule
+			case tier.UpTo.Valid && !tier.UpTo.Decimal.IsPositive():
+				return ErrInvalidFeeSchedule
+			case tier.UpTo.Valid && i > 0 && !tier.UpTo.Decimal.GreaterThan(f.Tiers[i-1].UpTo.Decimal):
+				return ErrInvalidFeeSchedule
+			}
+			amounts = append(amounts, tier.Flat, tier.UpTo.Decimal)
+			rates = append(rates, tier.Rate)
+		}
+	default:
+		return ErrInvalidFeeSchedule
+	}
+
+	if f.MinFee.Valid {
+		amounts = append(amounts, f.MinFee.Decimal)
+	}
+	if f.MaxFee.Valid {
+		amounts = append(amounts, f.MaxFee.Decimal)
+		if f.MinFee.Valid && f.MaxFee.Decimal.LessThan(f.MinFee.Decimal) {
+			return ErrInvalidFeeSchedule
+		}
+	}
+	for _, amount := range amounts {
+		if amount.IsNegative() {
+			return ErrInvalidFeeSchedule
+		}
+		if err := ValidateCurrencyAmount(f.Currency, amount); err != nil {
+			return err
+		}
+	}
+	for _, rate := range rates {
+		if rate.IsNegative() || rate.GreaterThanOrEqual(decimal.NewFromInt(1)) {
+			return ErrInvalidFeeSchedule
+		}
+	}
+	return nil
+}
+
+// Hold represents funds reserved on an account until they are captured, voided or the hold expires
+type Hold struct {
+	HoldID         int64               `json:"hold_id" db:"id"`
+	AccountID      int64               `json:"account_id" db:"account_id"`
+	Amount         decimal.Decimal     `json:"amount" db:"amount"`
+	Currency       string              `json:"currency" db:"currency"`
+	CapturedAmount decimal.NullDecimal `json:"captured_amount" db:"captured_amount"`
+	Status         HoldStatus          `json:"status" db:"status"`
+	Description    string              `json:"description" db:"description"`
+	ExpiresAt      time.Time           `json:"expires_at" db:"expires_at"`
+	TransactionID  *int64              `json:"transaction_id,omitempty" db:"transaction_id"`
+	CreatedAt      time.Time           `json:"created_at" db:"created_at"`
+	Owner          sql.NullString      `json:"-" db:"owner"`
+}
+
+// TransactionFilter narrows down the transaction history of an account; zero-valued fields do not filter.
+// From is inclusive, Before is exclusive and Until is inclusive, all on the transaction date.
+type TransactionFilter struct {
+	From            time.Time
+	Before          time.Time
+	Until           time.Time
+	IsCredit        *bool
+	MinAmount       decimal.NullDecimal
+	MaxAmount       decimal.NullDecimal
+	Description     string
+	TransactionType TransactionType
+	CounterpartyID  int64
+}
+
+// IdempotencyKey identifies a client request that must be applied at most once
+type IdempotencyKey struct {
+	Key         string
+	RequestHash string
+}
+
+// IdempotencyRecord represents the stored outcome of a request made with an idempotency key
+type IdempotencyRecord struct {
+	Key            string        `db:"idempotency_key"`
+	RequestHash    string        `db:"request_hash"`
+	ResponseStatus sql.NullInt64 `db:"response_status"`
+	ResponseBody   []byte        `db:"response_body"`
+}
+
+// BalanceDrift represents an account whose denormalized balance disagrees with the sum of its ledger legs
+type BalanceDrift struct {
+	AccountID           int64           `json:"account_id" db:"account_id"`
+	Currency            string          `json:"currency" db:"currency"`
+	DenormalizedBalance decimal.Decimal `json:"denormalized_balance" db:"denormalized_balance"`
+	LedgerBalance       decimal.Decimal `json:"ledger_balance" db:"ledger_balance"`
+	Drift               decimal.Decimal `json:"drift" db:"drift"`
+	Repaired            bool            `json:"repaired" db:"-"`
+	// LegsRepaired counts the ledger legs of the account whose balance_after was corrected by the repair
+	LegsRepaired int64 `json:"legs_repaired" db:"-"`
+}
+
+// LedgerImbalance represents a currency whose ledger legs paying out of accounts do not sum up to the legs paying in,
+// which every balanced transaction guarantees
+type LedgerImbalance struct {
+	Currency string          `json:"currency" db:"currency"`
+	Credits  decimal.Decimal `json:"credits" db:"credits"`
+	Debits   decimal.Decimal `json:"debits" db:"debits"`
+	// Difference is Credits minus Debits
+	Difference decimal.Decimal `json:"difference" db:"difference"`
+}
+
+// APIKey represents an issued API key. Only the SHA-256 hash of the key is stored;
+// Prefix keeps its first characters so a key can be recognized without revealing it.
+type APIKey struct {
+	ID        int64          `json:"id" db:"id"`
+	Prefix    string         `json:"key_prefix" db:"key_prefix"`
+	KeyHash   string         `json:"-" db:"key_hash"`
+	Subject   string         `json:"subject" db:"subject"`
+	Scopes    pq.StringArray `json:"scopes" db:"scopes"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+	RevokedAt sql.NullTime   `json:"-" db:"revoked_at"`
+}
+
+// Principal is the authenticated caller of a request, identified by the subject of its API key or bearer token,
+// with the scopes granted to the key or token.
+// Accounts are owned by the subject of the principal that created them; admins may act on every account.
+type Principal struct {
+	Subject string
+	Scopes  []Scope
+}
+
+// HasScope tells whether the principal was granted scope; ScopeLedgerAdmin grants every scope
+func (p Principal) HasScope(scope Scope) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeLedgerAdmin)
+}
+
+// IsAdmin tells whether the principal was granted ScopeLedgerAdmin
+func (p Principal) IsAdmin() bool {
+	return p.HasScope(ScopeLedgerAdmin)
+}
+
+// CanAccess tells whether the principal may act on an account with the given owner
+func (p Principal) CanAccess(owner sql.NullString) bool {
+	return p.IsAdmin() || (owner.Valid && owner.String == p.Subject)
 }
@@ -0,0 +1,100 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func d(value string) decimal.Decimal {
+	return decimal.RequireFromString(value)
+}
+
+func nd(value string) decimal.NullDecimal {
+	return decimal.NewNullDecimal(d(value))
+}
+
+// slabTiers charges 1 up to 100, 1% up to 1000 and 0.5% above, each rate applying to the whole amount
+var slabTiers = FeeTiers{
+	{UpTo: nd("100"), Flat: d("1")},
+	{UpTo: nd("1000"), Rate: d("0.01")},
+	{Rate: d("0.005")},
+}
+
+func TestFeeScheduleFee(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule FeeSchedule
+		amount   string
+		want     string
+	}{
+		{"no schedule", FeeSchedule{}, "100", "0"},
+		{"flat", FeeSchedule{Currency: "USD", FeeType: FeeTypeFlat, Flat: d("1.5")}, "100", "1.5"},
+		{"percentage rounded to the minor unit", FeeSchedule{Currency: "USD", FeeType: FeeTypePercentage, Rate: d("0.01")}, "123.45", "1.23"},
+		{"percentage rounded half up", FeeSchedule{Currency: "USD", FeeType: FeeTypePercentage, Rate: d("0.01")}, "150.5", "1.51"},
+		{"percentage in a currency without minor unit", FeeSchedule{Currency: "JPY", FeeType: FeeTypePercentage, Rate: d("0.015")}, "1001", "15"},
+		{"tiered below the first bound", FeeSchedule{Currency: "USD", FeeType: FeeTypeTiered, Tiers: slabTiers}, "50", "1"},
+		{"tiered at a bound takes the lower tier", FeeSchedule{Currency: "USD", FeeType: FeeTypeTiered, Tiers: slabTiers}, "100", "1"},
+		{"tiered just above a bound takes the next tier", FeeSchedule{Currency: "USD", FeeType: FeeTypeTiered, Tiers: slabTiers}, "100.01", "1"},
+		{"tiered rate applies to the whole amount", FeeSchedule{Currency: "USD", FeeType: FeeTypeTiered, Tiers: slabTiers}, "1000", "10"},
+		{"tiered fee jumps at a bound", FeeSchedule{Currency: "USD", FeeType: FeeTypeTiered, Tiers: slabTiers}, "1000.01", "5"},
+		{"tiered open last tier", FeeSchedule{Currency: "USD", FeeType: FeeTypeTiered, Tiers: slabTiers}, "5000", "25"},
+		{"tiered flat and rate", FeeSchedule{Currency: "USD", FeeType: FeeTypeTiered, Tiers: FeeTiers{{Flat: d("0.3"), Rate: d("0.029")}}}, "10", "0.59"},
+		{"raised to the minimum", FeeSchedule{Currency: "USD", FeeType: FeeTypePercentage, Rate: d("0.001"), MinFee: nd("0.5")}, "1", "0.5"},
+		{"capped at the maximum", FeeSchedule{Currency: "USD", FeeType: FeeTypePercentage, Rate: d("0.01"), MaxFee: nd("25")}, "100000", "25"},
+		{"clamped to the maximum before rounding", FeeSchedule{Currency: "USD", FeeType: FeeTypePercentage, Rate: d("0.01"), MaxFee: nd("25")}, "2500.4", "25"},
+		{"rounded within the bounds", FeeSchedule{Currency: "USD", FeeType: FeeTypePercentage, Rate: d("0.01"), MinFee: nd("1"), MaxFee: nd("25")}, "2499.4", "24.99"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.schedule.Fee(d(test.amount))
+			if !got.Equal(d(test.want)) {
+				t.Errorf("Fee(%s) = %s, want %s", test.amount, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFeeScheduleValidate(t *testing.T) {
+	withdrawal := func(schedule FeeSchedule) FeeSchedule {
+		schedule.TransactionType = TransactionTypeWithdrawal
+		if schedule.Currency == "" {
+			schedule.Currency = "USD"
+		}
+		return schedule
+	}
+	tests := []struct {
+		name     string
+		schedule FeeSchedule
+		want     error
+	}{
+		{"flat", withdrawal(FeeSchedule{FeeType: FeeTypeFlat, Flat: d("1")}), nil},
+		{"percentage with bounds", withdrawal(FeeSchedule{FeeType: FeeTypePercentage, Rate: d("0.01"), MinFee: nd("1"), MaxFee: nd("25")}), nil},
+		{"tiered", withdrawal(FeeSchedule{FeeType: FeeTypeTiered, Tiers: slabTiers}), nil},
+		{"tiered with a single open tier", withdrawal(FeeSchedule{FeeType: FeeTypeTiered, Tiers: FeeTiers{{Rate: d("0.01")}}}), nil},
+		{"transfer", FeeSchedule{TransactionType: TransactionTypeTransfer, Currency: "USD", FeeType: FeeTypeFlat, Flat: d("1")}, nil},
+		{"deposit", FeeSchedule{TransactionType: TransactionTypeDeposit, Currency: "USD", FeeType: FeeTypeFlat, Flat: d("1")}, ErrInvalidTransactionType},
+		{"unknown fee type", withdrawal(FeeSchedule{FeeType: "stepped", Flat: d("1")}), ErrInvalidFeeSchedule},
+		{"flat without fee", withdrawal(FeeSchedule{FeeType: FeeTypeFlat}), ErrInvalidFeeSchedule},
+		{"percentage without rate", withdrawal(FeeSchedule{FeeType: FeeTypePercentage}), ErrInvalidFeeSchedule},
+		{"rate of 100%", withdrawal(FeeSchedule{FeeType: FeeTypePercentage, Rate: d("1")}), ErrInvalidFeeSchedule},
+		{"negative tier rate", withdrawal(FeeSchedule{FeeType: FeeTypeTiered, Tiers: FeeTiers{{Rate: d("-0.01")}}}), ErrInvalidFeeSchedule},
+		{"tiered without tiers", withdrawal(FeeSchedule{FeeType: FeeTypeTiered}), ErrInvalidFeeSchedule},
+		{"tiered last tier bounded", withdrawal(FeeSchedule{FeeType: FeeTypeTiered, Tiers: FeeTiers{{UpTo: nd("100"), Flat: d("1")}}}), ErrInvalidFeeSchedule},
+		{"tiered open tier before the last", withdrawal(FeeSchedule{FeeType: FeeTypeTiered, Tiers: FeeTiers{{Flat: d("1")}, {Rate: d("0.01")}}}), ErrInvalidFeeSchedule},
+		{"tiered bound not positive", withdrawal(FeeSchedule{FeeType: FeeTypeTiered, Tiers: FeeTiers{{UpTo: nd("0"), Flat: d("1")}, {Rate: d("0.01")}}}), ErrInvalidFeeSchedule},
+		{"tiered bounds not ascending", withdrawal(FeeSchedule{FeeType: FeeTypeTiered, Tiers: FeeTiers{{UpTo: nd("100")}, {UpTo: nd("100")}, {Rate: d("0.01")}}}), ErrInvalidFeeSchedule},
+		{"maximum below minimum", withdrawal(FeeSchedule{FeeType: FeeTypePercentage, Rate: d("0.01"), MinFee: nd("5"), MaxFee: nd("1")}), ErrInvalidFeeSchedule},
+		{"negative minimum", withdrawal(FeeSchedule{FeeType: FeeTypePercentage, Rate: d("0.01"), MinFee: nd("-1")}), ErrInvalidFeeSchedule},
+		{"flat finer than the minor unit", withdrawal(FeeSchedule{FeeType: FeeTypeFlat, Flat: d("1.005")}), ErrInvalidAmountScale},
+		{"tier bound finer than the minor unit", withdrawal(FeeSchedule{Currency: "JPY", FeeType: FeeTypeTiered, Tiers: FeeTiers{{UpTo: nd("100.5")}, {Rate: d("0.01")}}}), ErrInvalidAmountScale},
+		{"unsupported currency", withdrawal(FeeSchedule{Currency: "XYZ", FeeType: FeeTypeFlat, Flat: d("1")}), ErrUnsupportedCurrency},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.schedule.Validate(); err != test.want {
+				t.Errorf("Validate() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
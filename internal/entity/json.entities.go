@@ -1,43 +1,512 @@
 package entity
 
-import "github.com/shopspring/decimal"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
 
-// CreateAccountRequest represents the request to create a new account
+	"github.com/jmoiron/sqlx/types"
+	"github.com/shopspring/decimal"
+)
+
+// CreateAccountRequest represents the request to create a new account.
+// ExternalRef and Metadata are optional; Metadata must be a JSON object.
 type CreateAccountRequest struct {
-	Name string `json:"account_name" binding:"required"`
+	Name        string          `json:"account_name" binding:"required"`
+	Currency    string          `json:"currency" binding:"required"`
+	ExternalRef string          `json:"external_ref"`
+	Metadata    json.RawMessage `json:"metadata"`
+}
+
+// UpdateAccountRequest represents a partial update of an account's profile; omitted fields are left unchanged.
+// An empty ExternalRef removes the external reference, Metadata replaces the stored object as a whole.
+type UpdateAccountRequest struct {
+	Name        *string         `json:"account_name"`
+	ExternalRef *string         `json:"external_ref"`
+	Metadata    json.RawMessage `json:"metadata"`
+}
+
+// WalletResponse represents the profile of an account along with its balance.
+// Balance is the ledger balance, AvailableBalance excludes funds reserved by active holds.
+type WalletResponse struct {
+	AccountID        int64           `json:"account_id"`
+	AccountName      string          `json:"account_name"`
+	Currency         string          `json:"currency"`
+	Owner            *string         `json:"owner"`
+	ExternalRef      *string         `json:"external_ref"`
+	Metadata         types.JSONText  `json:"metadata"`
+	Status           AccountStatus   `json:"status"`
+	Balance          decimal.Decimal `json:"balance"`
+	AvailableBalance decimal.Decimal `json:"available_balance"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+}
+
+// NewWalletResponse builds the wallet resource of an account
+func NewWalletResponse(account Account) WalletResponse {
+	response := WalletResponse{
+		AccountID:        account.AccountID,
+		AccountName:      account.Name,
+		Currency:         account.Currency,
+		Metadata:         account.Metadata,
+		Status:           account.Status,
+		Balance:          account.Balance,
+		AvailableBalance: account.Available(),
+		CreatedAt:        account.CreatedAt,
+		UpdatedAt:        account.UpdatedAt,
+	}
+	if account.Owner.Valid {
+		response.Owner = &account.Owner.String
+	}
+	if account.ExternalRef.Valid {
+		response.ExternalRef = &account.ExternalRef.String
+	}
+	return response
+}
+
+// ChangeAccountStatusRequest represents the request to freeze, unfreeze or close an account.
+// BlockCredits only applies to freezing and also blocks incoming funds.
+type ChangeAccountStatusRequest struct {
+	Reason       string `json:"reason" binding:"required"`
+	BlockCredits bool   `json:"block_credits"`
+}
+
+// AccountStatusResponse represents the status of an account after a status change
+type AccountStatusResponse struct {
+	AccountID    int64         `json:"account_id"`
+	Status       AccountStatus `json:"status"`
+	BlockCredits bool          `json:"block_credits"`
+}
+
+// SetLimitsRequest sets the transaction limits of a wallet or the default limits of a currency.
+// A null limit is unset: on a wallet it falls back to the currency default, on a currency it does not cap anything.
+type SetLimitsRequest struct {
+	PerTransaction decimal.NullDecimal `json:"per_transaction"`
+	Daily          decimal.NullDecimal `json:"daily"`
+}
+
+// Validate checks that every limit set is positive and fits the minor unit of the currency
+func (r SetLimitsRequest) Validate(currency string) error {
+	for _, limit := range []decimal.NullDecimal{r.PerTransaction, r.Daily} {
+		if !limit.Valid {
+			continue
+		}
+		if !limit.Decimal.IsPositive() {
+			return ErrAmountNotPositive
+		}
+		if err := ValidateCurrencyAmount(currency, limit.Decimal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Limits returns the transaction limits set by the request
+func (r SetLimitsRequest) Limits() TransactionLimits {
+	return TransactionLimits{PerTransaction: r.PerTransaction, Daily: r.Daily}
+}
+
+// LimitExceededResponse represents the response to a payout breaking a transaction limit,
+// with the fields of the LimitExceededError
+type LimitExceededResponse struct {
+	Error string `json:"error"`
+	*LimitExceededError
+}
+
+// WalletLimitsResponse represents the transaction limits of a wallet and how much of them is left
+type WalletLimitsResponse struct {
+	AccountID int64  `json:"account_id"`
+	Currency  string `json:"currency"`
+	// Overrides are the limits set on the wallet itself
+	Overrides TransactionLimits `json:"overrides"`
+	// Effective are the limits applied, the overrides falling back to the defaults of the currency
+	Effective TransactionLimits `json:"effective"`
+	// PaidOut is the total paid out by withdrawals and outgoing transfers over the last 24 hours
+	PaidOut decimal.Decimal `json:"paid_out_last_24h"`
+	// Remaining is the largest amount the wallet can pay out right now, null when it is not limited
+	Remaining decimal.NullDecimal `json:"remaining"`
 }
 
-// CreateAccountResponse represents the response after creating a new account
-type CreateAccountResponse struct {
-	AccountID   int64  `json:"account_id"`
-	AccountName string `json:"account_name"`
+// CurrencyLimitsResponse represents the default transaction limits of a currency
+type CurrencyLimitsResponse struct {
+	Currency string `json:"currency"`
+	TransactionLimits
 }
 
-// GetBalanceResponse represents the response for balance queries
-type GetBalanceResponse struct {
+// SetFeeScheduleRequest represents the fee schedule of a transaction type and currency, see FeeSchedule
+type SetFeeScheduleRequest struct {
+	FeeType FeeType             `json:"fee_type" binding:"required"`
+	Flat    decimal.Decimal     `json:"flat"`
+	Rate    decimal.Decimal     `json:"rate"`
+	Tiers   FeeTiers            `json:"tiers"`
+	MinFee  decimal.NullDecimal `json:"min_fee"`
+	MaxFee  decimal.NullDecimal `json:"max_fee"`
+}
+
+// Schedule returns the fee schedule set by the request for a transaction type and currency
+func (r SetFeeScheduleRequest) Schedule(transactionType TransactionType, currency string) FeeSchedule {
+	return FeeSchedule{
+		TransactionType: transactionType,
+		Currency:        currency,
+		FeeType:         r.FeeType,
+		Flat:            r.Flat,
+		Rate:            r.Rate,
+		Tiers:           r.Tiers,
+		MinFee:          r.MinFee,
+		MaxFee:          r.MaxFee,
+	}
+}
+
+// FeeQuoteResponse represents the fee a withdrawal or transfer of an amount would be charged
+type FeeQuoteResponse struct {
+	TransactionType TransactionType `json:"transaction_type"`
+	Currency        string          `json:"currency"`
+	Amount          decimal.Decimal `json:"amount"`
+	Fee             decimal.Decimal `json:"fee"`
+	// Total is what the payer is debited, the amount plus the fee
+	Total decimal.Decimal `json:"total"`
+}
+
+// GetBalanceAsOfResponse represents the response for point-in-time balance queries
+type GetBalanceAsOfResponse struct {
 	AccountID int64           `json:"account_id"`
+	Currency  string          `json:"currency"`
 	Balance   decimal.Decimal `json:"balance"`
+	AsOf      time.Time       `json:"as_of"`
+}
+
+// Statement describes the monthly statement of an account, covering From (inclusive) to To (exclusive)
+type Statement struct {
+	AccountID      int64           `json:"account_id"`
+	Currency       string          `json:"currency"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance decimal.Decimal `json:"opening_balance"`
+}
+
+// StatementRecord is one line of a JSON Lines statement: the opening balance, an entry or the closing balance
+type StatementRecord struct {
+	Record        string           `json:"record"`
+	Date          time.Time        `json:"date"`
+	TransactionID int              `json:"transaction_id,omitempty"`
+	LedgerID      int              `json:"ledger_id,omitempty"`
+	Type          EntryType        `json:"type,omitempty"`
+	Description   string           `json:"description,omitempty"`
+	Amount        *decimal.Decimal `json:"amount,omitempty"`
+	Currency      string           `json:"currency"`
+	Balance       decimal.Decimal  `json:"balance"`
 }
 
-// TransactionListResponse represents the response for transaction history queries
+// TransactionListResponse represents the response for transaction history queries.
+// NextCursor is set when more transactions follow and can be passed back as the cursor query param.
 type TransactionListResponse struct {
 	AccountID    int64               `json:"account_id"`
 	StartDate    string              `json:"start_date,omitempty"`
 	EndDate      string              `json:"end_date,omitempty"`
 	Transactions []TransactionDetail `json:"transactions"`
+	NextCursor   string              `json:"next_cursor,omitempty"`
+}
+
+// TransactionResponse represents a transaction with its ledger legs
+type TransactionResponse struct {
+	TransactionID   int64                    `json:"transaction_id"`
+	Type            TransactionType          `json:"type"`
+	TransactionDate time.Time                `json:"transaction_date"`
+	Description     string                   `json:"description"`
+	Amount          decimal.Decimal          `json:"amount"`
+	Currency        string                   `json:"currency"`
+	FXRate          *decimal.Decimal         `json:"fx_rate,omitempty"`
+	Fee             decimal.Decimal          `json:"fee"`
+	ReversalOf      *int64                   `json:"reversal_of,omitempty"`
+	Status          TransactionStatus        `json:"status"`
+	Legs            []TransactionLegResponse `json:"legs"`
+	// Reversals lists the reversals of the transaction, oldest first
+	Reversals []ReversalLink `json:"reversals"`
+}
+
+// ReversalLink represents a reversal of a transaction, Href being the path of the reversal transaction
+type ReversalLink struct {
+	TransactionID   int64           `json:"transaction_id"`
+	TransactionDate time.Time       `json:"transaction_date"`
+	Amount          decimal.Decimal `json:"amount"`
+	Href            string          `json:"href"`
+}
+
+// TransactionLegResponse represents a ledger leg of a transaction.
+// BalanceAfter is only reported for customer accounts.
+type TransactionLegResponse struct {
+	LedgerID      int64            `json:"ledger_id"`
+	AccountID     int64            `json:"account_id"`
+	SystemAccount string           `json:"system_account,omitempty"`
+	Amount        decimal.Decimal  `json:"amount"`
+	Currency      string           `json:"currency"`
+	IsCredit      bool             `json:"is_credit"`
+	BalanceAfter  *decimal.Decimal `json:"balance_after,omitempty"`
+}
+
+// NewTransactionResponse builds the response of a transaction from its row and ledger legs
+func NewTransactionResponse(transaction Transaction, ledgers []Ledger) TransactionResponse {
+	response := TransactionResponse{
+		TransactionID:   transaction.ID,
+		Type:            transaction.Type,
+		TransactionDate: transaction.TransactionDate,
+		Description:     transaction.Description,
+		Amount:          transaction.Amount,
+		Currency:        transaction.Currency,
+		Fee:             transaction.Fee,
+		Status:          TransactionStatusPosted,
+		Legs:            make([]TransactionLegResponse, 0, len(ledgers)),
+		Reversals:       []ReversalLink{},
+	}
+	if transaction.FXRate.Valid {
+		response.FXRate = &transaction.FXRate.Decimal
+	}
+	if transaction.ReversalOf.Valid {
+		response.ReversalOf = &transaction.ReversalOf.Int64
+	}
+	for _, ledger := range ledgers {
+		leg := TransactionLegResponse{
+			LedgerID:      ledger.ID,
+			AccountID:     ledger.AccountID,
+			SystemAccount: ledger.SystemCode.String,
+			Amount:        ledger.Amount,
+			Currency:      ledger.Currency,
+			IsCredit:      ledger.IsCredit,
+		}
+		if ledger.BalanceAfter.Valid {
+			balanceAfter := ledger.BalanceAfter.Decimal
+			leg.BalanceAfter = &balanceAfter
+		}
+		response.Legs = append(response.Legs, leg)
+	}
+	return response
+}
+
+// AddReversals links the reversals of a transaction and derives its status from the amount they reversed
+func (r *TransactionResponse) AddReversals(reversals []Transaction) {
+	reversed := decimal.Zero
+	for _, reversal := range reversals {
+		r.Reversals = append(r.Reversals, ReversalLink{
+			TransactionID:   reversal.ID,
+			TransactionDate: reversal.TransactionDate,
+			Amount:          reversal.Amount,
+			Href:            fmt.Sprintf("/transactions/%d", reversal.ID),
+		})
+		reversed = reversed.Add(reversal.Amount)
+	}
+
+	switch {
+	case reversed.GreaterThanOrEqual(r.Amount):
+		r.Status = TransactionStatusReversed
+	case reversed.IsPositive():
+		r.Status = TransactionStatusPartiallyReversed
+	}
 }
 
 // CreateTransactionRequest represents the request to create a transaction
 type CreateTransactionRequest struct {
 	Amount          decimal.Decimal `json:"amount" binding:"required"`
+	Currency        string          `json:"currency" binding:"required"`
 	Description     string          `json:"description" binding:"required"`
 	TransactionType TransactionType `json:"transaction_type" binding:"required"`
 }
 
+// Validate checks a deposit or withdrawal request; the currency is expected in upper case
+func (r CreateTransactionRequest) Validate() error {
+	if r.Amount.LessThanOrEqual(decimal.Zero) {
+		return ErrAmountNotPositive
+	}
+	if err := validateDescription(r.Description); err != nil {
+		return err
+	}
+	if err := ValidateCurrencyAmount(r.Currency, r.Amount); err != nil {
+		return err
+	}
+	if r.TransactionType != TransactionTypeDeposit && r.TransactionType != TransactionTypeWithdrawal {
+		return ErrInvalidTransactionType
+	}
+	return nil
+}
+
 // CreateTransferRequest represents the request to create a transfer transaction
 type CreateTransferRequest struct {
 	FromAccountID int64           `json:"from_account_id" binding:"required"`
 	ToAccountID   int64           `json:"to_account_id" binding:"required"`
 	Amount        decimal.Decimal `json:"amount" binding:"required"`
+	Currency      string          `json:"currency" binding:"required"`
 	Description   string          `json:"description" binding:"required"`
+	// Convert must be set to transfer between wallets of different currencies
+	Convert bool `json:"convert"`
+}
+
+// Validate checks a transfer request; the currency is expected in upper case
+func (r CreateTransferRequest) Validate() error {
+	if r.Amount.LessThanOrEqual(decimal.Zero) {
+		return ErrAmountNotPositive
+	}
+	if r.FromAccountID == r.ToAccountID {
+		return ErrSameAccountTransfer
+	}
+	if err := validateDescription(r.Description); err != nil {
+		return err
+	}
+	return ValidateCurrencyAmount(r.Currency, r.Amount)
+}
+
+// CreateBatchTransferRequest represents the request to pay many wallets from one source wallet at once.
+// Transfers without a description use the description of the batch.
+type CreateBatchTransferRequest struct {
+	FromAccountID int64                  `json:"from_account_id" binding:"required"`
+	Currency      string                 `json:"currency" binding:"required"`
+	Description   string                 `json:"description" binding:"required"`
+	Transfers     []BatchTransferRequest `json:"transfers" binding:"required"`
+}
+
+// BatchTransferRequest represents a single transfer of a batch
+type BatchTransferRequest struct {
+	ToAccountID int64           `json:"to_account_id" binding:"required"`
+	Amount      decimal.Decimal `json:"amount" binding:"required"`
+	Description string          `json:"description"`
+}
+
+// Validate checks a batch transfer request; the currency is expected in upper case
+func (r CreateBatchTransferRequest) Validate() error {
+	if len(r.Transfers) == 0 || len(r.Transfers) > MaxBatchTransfers {
+		return ErrInvalidBatchSize
+	}
+	if err := validateDescription(r.Description); err != nil {
+		return err
+	}
+	for _, transfer := range r.Transfers {
+		if transfer.Amount.LessThanOrEqual(decimal.Zero) {
+			return ErrAmountNotPositive
+		}
+		if transfer.ToAccountID == r.FromAccountID {
+			return ErrSameAccountTransfer
+		}
+		if transfer.Description != "" {
+			if err := validateDescription(transfer.Description); err != nil {
+				return err
+			}
+		}
+		if err := ValidateCurrencyAmount(r.Currency, transfer.Amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchTransferResponse represents the outcome of a batch transfer, with one transaction per transfer in request order
+type BatchTransferResponse struct {
+	FromAccountID int64                 `json:"from_account_id"`
+	Currency      string                `json:"currency"`
+	TotalAmount   decimal.Decimal       `json:"total_amount"`
+	TotalFee      decimal.Decimal       `json:"total_fee"`
+	Transfers     []BatchTransferResult `json:"transfers"`
+}
+
+// BatchTransferResult represents a booked transfer of a batch
+type BatchTransferResult struct {
+	TransactionID int64           `json:"transaction_id"`
+	ToAccountID   int64           `json:"to_account_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Fee           decimal.Decimal `json:"fee"`
+}
+
+// CreateReversalRequest represents the request to reverse a transaction.
+// A zero amount reverses the remaining amount of the transaction.
+type CreateReversalRequest struct {
+	Amount      decimal.Decimal `json:"amount"`
+	Description string          `json:"description"`
+}
+
+// CreateHoldRequest represents the request to reserve funds on an account.
+// Holds expire after 7 days unless ExpiresInSeconds is set.
+type CreateHoldRequest struct {
+	Amount           decimal.Decimal `json:"amount" binding:"required"`
+	Currency         string          `json:"currency" binding:"required"`
+	Description      string          `json:"description" binding:"required"`
+	ExpiresInSeconds int64           `json:"expires_in_seconds"`
+}
+
+// CaptureHoldRequest represents the request to capture a hold.
+// A zero amount captures the full hold.
+type CaptureHoldRequest struct {
+	Amount      decimal.Decimal `json:"amount"`
+	Description string          `json:"description"`
+}
+
+// CreateMultiLegTransactionRequest represents the request to book several legs as one transaction.
+// Legs with IsCredit set take the amount out of the account, the others pay it in.
+type CreateMultiLegTransactionRequest struct {
+	Currency    string                  `json:"currency" binding:"required"`
+	Description string                  `json:"description" binding:"required"`
+	Legs        []TransactionLegRequest `json:"legs" binding:"required"`
+}
+
+// TransactionLegRequest represents a single leg of a multi-leg transaction
+type TransactionLegRequest struct {
+	AccountID int64           `json:"account_id" binding:"required"`
+	Amount    decimal.Decimal `json:"amount" binding:"required"`
+	IsCredit  bool            `json:"is_credit"`
+}
+
+// ImportRowResult represents the outcome of one row of a bulk import; Row counts data rows from 1
+type ImportRowResult struct {
+	Row    int             `json:"row"`
+	Status ImportRowStatus `json:"status"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// ImportReport represents the outcome of a bulk import.
+// An atomic import is applied only when every row succeeds.
+type ImportReport struct {
+	Atomic    bool              `json:"atomic"`
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Rows      []ImportRowResult `json:"rows"`
+}
+
+// ReconciliationRequest represents the request to reconcile balances against the ledger
+type ReconciliationRequest struct {
+	Repair bool `json:"repair"`
+}
+
+// ReconciliationReport represents the outcome of reconciling balances against the ledger
+type ReconciliationReport struct {
+	CheckedAt       time.Time      `json:"checked_at"`
+	AccountsChecked int64          `json:"accounts_checked"`
+	Drifts          []BalanceDrift `json:"drifts"`
+	// Imbalances lists the currencies whose ledger does not balance; they cannot be repaired automatically
+	Imbalances []LedgerImbalance `json:"imbalances"`
+}
+
+// HistoryPage selects a page of transaction history, starting after Cursor when set
+type HistoryPage struct {
+	Limit  int
+	Cursor *HistoryCursor
+}
+
+// HistoryCursor points at the last ledger leg of a history page.
+// Clients only see it as an opaque string, see EncodeHistoryCursor.
+type HistoryCursor struct {
+	TransactionDate time.Time `json:"d"`
+	LedgerID        int       `json:"l"`
+}
+
+func EncodeHistoryCursor(cursor HistoryCursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+func DecodeHistoryCursor(encoded string) (HistoryCursor, error) {
+	var cursor HistoryCursor
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(payload, &cursor)
+	return cursor, err
 }
@@ -0,0 +1,60 @@
+package fx
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// RateProvider supplies the mid-market rate for converting one unit of a currency into another
+type RateProvider interface {
+	GetRate(fromCurrency, toCurrency string) (decimal.Decimal, error)
+}
+
+// StaticRateProvider serves exchange rates from a fixed table.
+// Rates are keyed by "FROM/TO" currency pairs, e.g. "USD/EUR".
+type StaticRateProvider struct {
+	rates map[string]decimal.Decimal
+}
+
+func NewStaticRateProvider(rates map[string]decimal.Decimal) *StaticRateProvider {
+	normalized := make(map[string]decimal.Decimal, len(rates))
+	for pair, rate := range rates {
+		normalized[strings.ToUpper(pair)] = rate
+	}
+	return &StaticRateProvider{
+		rates: normalized,
+	}
+}
+
+// NewFileRateProvider loads a StaticRateProvider from a JSON file mapping currency pairs to rates,
+// e.g. {"USD/EUR": "0.92", "USD/IDR": "16250"}
+func NewFileRateProvider(path string) (*StaticRateProvider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates map[string]decimal.Decimal
+	if err := json.Unmarshal(content, &rates); err != nil {
+		return nil, err
+	}
+	return NewStaticRateProvider(rates), nil
+}
+
+// GetRate returns the rate for the pair, falling back to the inverse of the opposite pair
+func (p *StaticRateProvider) GetRate(fromCurrency, toCurrency string) (decimal.Decimal, error) {
+	if fromCurrency == toCurrency {
+		return decimal.NewFromInt(1), nil
+	}
+	if rate, ok := p.rates[fromCurrency+"/"+toCurrency]; ok && rate.IsPositive() {
+		return rate, nil
+	}
+	if rate, ok := p.rates[toCurrency+"/"+fromCurrency]; ok && rate.IsPositive() {
+		return decimal.NewFromInt(1).DivRound(rate, 18), nil
+	}
+	return decimal.Zero, entity.ErrExchangeRateUnavailable
+}
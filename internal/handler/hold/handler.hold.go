@@ -0,0 +1,241 @@
+package hold
+
+import (
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/handler/respond"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/middleware"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultHoldExpiry = 7 * 24 * time.Hour
+	maxHoldExpiry     = 30 * 24 * time.Hour
+)
+
+type HoldServiceInterface interface {
+	CreateHold(principal entity.Principal, accountID int64, amount decimal.Decimal, currency, description string, expiresIn time.Duration) (entity.Hold, error)
+	GetHold(principal entity.Principal, holdID int64) (entity.Hold, error)
+	CaptureHold(principal entity.Principal, holdID int64, amount decimal.Decimal, description string) (entity.Hold, error)
+	VoidHold(principal entity.Principal, holdID int64) (entity.Hold, error)
+}
+
+type Handler struct {
+	holdService HoldServiceInterface
+}
+
+func NewHandler(holdService HoldServiceInterface) *Handler {
+	return &Handler{
+		holdService: holdService,
+	}
+}
+
+func (h *Handler) CreateHold(ctx *gin.Context) {
+	var request entity.CreateHoldRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if request.Amount.LessThanOrEqual(decimal.Zero) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+		return
+	}
+
+	if request.Description == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Description is required"})
+		return
+	}
+
+	if len(request.Description) > 100 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Description must be less than 100 characters"})
+		return
+	}
+
+	request.Currency = strings.ToUpper(request.Currency)
+	err := entity.ValidateCurrencyAmount(request.Currency, request.Amount)
+	if err == entity.ErrUnsupportedCurrency {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
+		return
+	}
+	if err == entity.ErrInvalidAmountScale {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount has more decimal places than the currency allows"})
+		return
+	}
+
+	// The expiry is checked in seconds, before converting it to a Duration which could overflow
+	expiresIn := defaultHoldExpiry
+	if request.ExpiresInSeconds != 0 {
+		if request.ExpiresInSeconds < 0 || request.ExpiresInSeconds > int64(maxHoldExpiry/time.Second) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Hold expiry must be between 1 second and 30 days"})
+			return
+		}
+		expiresIn = time.Duration(request.ExpiresInSeconds) * time.Second
+	}
+
+	accountIDStr := ctx.Param("id")
+	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	hold, err := h.holdService.CreateHold(middleware.GetPrincipal(ctx), accountID, request.Amount, request.Currency, request.Description, expiresIn)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if err == entity.ErrSystemAccount {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "System accounts cannot be used directly"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrCurrencyMismatch {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Currency does not match the account currency"})
+			return
+		}
+		if err == entity.ErrInsufficientFunds {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds for hold"})
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error creating hold for account %d: %v", accountID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create hold"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, hold)
+}
+
+func (h *Handler) GetHold(ctx *gin.Context) {
+	holdID, ok := parseHoldID(ctx)
+	if !ok {
+		return
+	}
+
+	hold, err := h.holdService.GetHold(middleware.GetPrincipal(ctx), holdID)
+	if err != nil {
+		if err == entity.ErrHoldNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Hold not found"})
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this hold is not allowed"})
+			return
+		}
+		log.Printf("Error getting hold %d: %v", holdID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get hold"})
+		return
+	}
+	ctx.JSON(http.StatusOK, hold)
+}
+
+func (h *Handler) CaptureHold(ctx *gin.Context) {
+	var request entity.CaptureHoldRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if request.Amount.IsNegative() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+		return
+	}
+
+	if len(request.Description) > 100 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Description must be less than 100 characters"})
+		return
+	}
+
+	holdID, ok := parseHoldID(ctx)
+	if !ok {
+		return
+	}
+
+	hold, err := h.holdService.CaptureHold(middleware.GetPrincipal(ctx), holdID, request.Amount, request.Description)
+	if err != nil {
+		if err == entity.ErrHoldNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Hold not found"})
+			return
+		}
+		if err == entity.ErrHoldNotActive {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Hold is no longer active"})
+			return
+		}
+		if err == entity.ErrCaptureExceedsHold {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Capture amount exceeds the held amount"})
+			return
+		}
+		if err == entity.ErrInvalidAmountScale {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount has more decimal places than the currency allows"})
+			return
+		}
+		if respond.LimitExceeded(ctx, err) {
+			return
+		}
+		if err == entity.ErrInsufficientFunds {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds for capture"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this hold is not allowed"})
+			return
+		}
+		log.Printf("Error capturing hold %d: %v", holdID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to capture hold"})
+		return
+	}
+	ctx.JSON(http.StatusOK, hold)
+}
+
+func (h *Handler) VoidHold(ctx *gin.Context) {
+	holdID, ok := parseHoldID(ctx)
+	if !ok {
+		return
+	}
+
+	hold, err := h.holdService.VoidHold(middleware.GetPrincipal(ctx), holdID)
+	if err != nil {
+		if err == entity.ErrHoldNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Hold not found"})
+			return
+		}
+		if err == entity.ErrHoldNotActive {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Hold is no longer active"})
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this hold is not allowed"})
+			return
+		}
+		log.Printf("Error voiding hold %d: %v", holdID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to void hold"})
+		return
+	}
+	ctx.JSON(http.StatusOK, hold)
+}
+
+func parseHoldID(ctx *gin.Context) (int64, bool) {
+	holdID, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hold ID"})
+		return 0, false
+	}
+	return holdID, true
+}
@@ -0,0 +1,41 @@
+package reconciliation
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+)
+
+type ReconciliationServiceInterface interface {
+	Reconcile(repair bool) (entity.ReconciliationReport, error)
+}
+
+type Handler struct {
+	reconciliationService ReconciliationServiceInterface
+}
+
+func NewHandler(reconciliationService ReconciliationServiceInterface) *Handler {
+	return &Handler{
+		reconciliationService: reconciliationService,
+	}
+}
+
+// Reconcile reports accounts whose balance disagrees with their ledger, repairing them when requested
+func (h *Handler) Reconcile(ctx *gin.Context) {
+	var request entity.ReconciliationRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	report, err := h.reconciliationService.Reconcile(request.Repair)
+	if err != nil {
+		log.Printf("Error reconciling balances: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile balances"})
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
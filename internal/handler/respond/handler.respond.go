@@ -0,0 +1,38 @@
+package respond
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+)
+
+// AccountStatus writes the response to an operation refused by the status of an account:
+// 403 Forbidden when the account is frozen, 409 Conflict when it is closed.
+// It returns false, writing nothing, for any other error.
+func AccountStatus(ctx *gin.Context, err error) bool {
+	switch err {
+	case entity.ErrAccountFrozen:
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Account is frozen"})
+	case entity.ErrAccountClosed:
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Account is closed"})
+	default:
+		return false
+	}
+	return true
+}
+
+// LimitExceeded writes a 422 Unprocessable Entity response with the limit and the remaining allowance when err is
+// a transaction limit being exceeded. It returns false, writing nothing, for any other error.
+func LimitExceeded(ctx *gin.Context, err error) bool {
+	var limitErr *entity.LimitExceededError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+	ctx.JSON(http.StatusUnprocessableEntity, entity.LimitExceededResponse{
+		Error:              "Transaction limit exceeded",
+		LimitExceededError: limitErr,
+	})
+	return true
+}
@@ -0,0 +1,87 @@
+package statement
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/middleware"
+)
+
+// statementContentTypes maps the supported statement formats to their content type
+var statementContentTypes = map[entity.StatementFormat]string{
+	entity.StatementFormatCSV:   "text/csv; charset=utf-8",
+	entity.StatementFormatJSONL: "application/x-ndjson",
+	entity.StatementFormatText:  "text/plain; charset=utf-8",
+}
+
+type StatementServiceInterface interface {
+	OpenStatement(principal entity.Principal, accountID int64, month time.Time) (entity.Statement, error)
+	WriteStatement(statement entity.Statement, format entity.StatementFormat, w io.Writer) error
+}
+
+type Handler struct {
+	statementService StatementServiceInterface
+}
+
+func NewHandler(statementService StatementServiceInterface) *Handler {
+	return &Handler{
+		statementService: statementService,
+	}
+}
+
+// GetStatement streams the monthly statement of a wallet as an attachment
+func (h *Handler) GetStatement(ctx *gin.Context) {
+	accountIDStr := ctx.Param("id")
+	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	month, err := time.Parse("2006-01", ctx.Query("month"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid month, expected YYYY-MM"})
+		return
+	}
+	if month.After(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Month must not be in the future"})
+		return
+	}
+
+	format := entity.StatementFormat(ctx.DefaultQuery("format", string(entity.StatementFormatCSV)))
+	contentType, ok := statementContentTypes[format]
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected csv, jsonl or txt"})
+		return
+	}
+
+	statement, err := h.statementService.OpenStatement(middleware.GetPrincipal(ctx), accountID, month)
+	if err != nil {
+		if err == entity.ErrAccountNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error opening statement of account %d for %s: %v", accountID, month.Format("2006-01"), err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get statement"})
+		return
+	}
+
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%d-%s.%s"`, accountID, month.Format("2006-01"), format))
+	ctx.Status(http.StatusOK)
+	err = h.statementService.WriteStatement(statement, format, ctx.Writer)
+	if err != nil {
+		// The status has been sent already, a statement without its closing balance line is incomplete
+		log.Printf("Error writing statement of account %d for %s: %v", accountID, month.Format("2006-01"), err)
+	}
+}
@@ -0,0 +1,99 @@
+package transaction
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// QuoteFee returns the fee a withdrawal or transfer would be charged, from the transaction_type, currency and amount query params
+func (h *Handler) QuoteFee(ctx *gin.Context) {
+	transactionType := entity.TransactionType(ctx.Query("transaction_type"))
+	if transactionType != entity.TransactionTypeWithdrawal && transactionType != entity.TransactionTypeTransfer {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction type, expected withdrawal or transfer"})
+		return
+	}
+	amount, err := decimal.NewFromString(ctx.Query("amount"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount"})
+		return
+	}
+	if !amount.IsPositive() {
+		validateRequest(ctx, entity.ErrAmountNotPositive)
+		return
+	}
+	currency := strings.ToUpper(ctx.Query("currency"))
+	if !validateCurrencyAmount(ctx, currency, amount) {
+		return
+	}
+
+	quote, err := h.transactionService.QuoteFee(transactionType, currency, amount)
+	if err != nil {
+		log.Printf("Error quoting %s fee on %s %s: %v", transactionType, amount, currency, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to quote fee"})
+		return
+	}
+	ctx.JSON(http.StatusOK, quote)
+}
+
+// GetFeeSchedules lists the fee schedules of every transaction type and currency
+func (h *Handler) GetFeeSchedules(ctx *gin.Context) {
+	schedules, err := h.transactionService.GetFeeSchedules()
+	if err != nil {
+		log.Printf("Error getting fee schedules: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get fee schedules"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"fee_schedules": schedules})
+}
+
+// SetFeeSchedule replaces the fee schedule of the transaction type and currency of the path
+func (h *Handler) SetFeeSchedule(ctx *gin.Context) {
+	var request entity.SetFeeScheduleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	transactionType := entity.TransactionType(ctx.Param("transaction_type"))
+	currency := strings.ToUpper(ctx.Param("currency"))
+	schedule, err := h.transactionService.SetFeeSchedule(request.Schedule(transactionType, currency))
+	if err != nil {
+		switch err {
+		case entity.ErrInvalidTransactionType:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction type, expected withdrawal or transfer"})
+		case entity.ErrUnsupportedCurrency:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
+		case entity.ErrInvalidAmountScale:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Fee amount has more decimal places than the currency allows"})
+		case entity.ErrInvalidFeeSchedule:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fee schedule"})
+		default:
+			log.Printf("Error setting %s fee schedule of %s: %v", transactionType, currency, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set fee schedule"})
+		}
+		return
+	}
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+// DeleteFeeSchedule stops charging fees on the transaction type and currency of the path
+func (h *Handler) DeleteFeeSchedule(ctx *gin.Context) {
+	transactionType := entity.TransactionType(ctx.Param("transaction_type"))
+	currency := strings.ToUpper(ctx.Param("currency"))
+	err := h.transactionService.DeleteFeeSchedule(transactionType, currency)
+	if err != nil {
+		if err == entity.ErrFeeScheduleNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Fee schedule not found"})
+			return
+		}
+		log.Printf("Error deleting %s fee schedule of %s: %v", transactionType, currency, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete fee schedule"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -1,20 +1,44 @@
 package transaction
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/handler/respond"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/middleware"
 	"github.com/shopspring/decimal"
 )
 
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxTransactionLegs   = 100
+	maxImportFileSize    = 10 << 20
+)
+
 type TransactionServiceInterface interface {
-	HandleDeposit(accountID int64, amount decimal.Decimal, description string) error
-	HandleWithdraw(accountID int64, amount decimal.Decimal, description string) error
-	HandleTransfer(fromAccountID, toAccountID int64, amount decimal.Decimal, description string) error
+	HandleDeposit(principal entity.Principal, accountID int64, amount decimal.Decimal, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error)
+	HandleWithdraw(principal entity.Principal, accountID int64, amount decimal.Decimal, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error)
+	HandleTransfer(principal entity.Principal, fromAccountID, toAccountID int64, amount decimal.Decimal, currency string, convert bool, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error)
+	HandleBatchTransfer(principal entity.Principal, fromAccountID int64, transfers []entity.BatchTransferRequest, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.BatchTransferResponse, error)
+	HandleMultiLegTransaction(principal entity.Principal, legs []entity.LedgerLeg, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error)
+	HandleReversal(principal entity.Principal, transactionID int64, amount decimal.Decimal, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error)
+	ImportTransactions(principal entity.Principal, file io.Reader, atomic bool) (entity.ImportReport, error)
+	GetTransaction(principal entity.Principal, transactionID int64) (entity.TransactionResponse, error)
+	QuoteFee(transactionType entity.TransactionType, currency string, amount decimal.Decimal) (entity.FeeQuoteResponse, error)
+	GetFeeSchedules() ([]entity.FeeSchedule, error)
+	SetFeeSchedule(schedule entity.FeeSchedule) (entity.FeeSchedule, error)
+	DeleteFeeSchedule(transactionType entity.TransactionType, currency string) error
+	GetIdempotencyRecord(idempotencyKey string) (entity.IdempotencyRecord, error)
 }
 
 type Handler struct {
@@ -36,33 +60,32 @@ func (h *Handler) HandleNewTransaction(ctx *gin.Context) {
 		return
 	}
 
-	if request.Amount.LessThanOrEqual(decimal.Zero) {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+	request.Currency = strings.ToUpper(request.Currency)
+	if !validateRequest(ctx, request.Validate()) {
 		return
 	}
 
-	if request.Description == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Description is required"})
+	accountIDStr := ctx.Param("id")
+	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
 		return
 	}
 
-	if len(request.Description) > 100 {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Description must be less than 100 characters"})
+	idempotencyKey, ok := readIdempotencyKey(ctx, request)
+	if !ok {
 		return
 	}
-
-	accountIDStr := ctx.Param("id")
-	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+	if h.replayIdempotentRequest(ctx, idempotencyKey) {
 		return
 	}
 
+	var transaction entity.TransactionResponse
 	switch request.TransactionType {
 	case entity.TransactionTypeDeposit:
-		err = h.transactionService.HandleDeposit(accountID, request.Amount, request.Description)
+		transaction, err = h.transactionService.HandleDeposit(middleware.GetPrincipal(ctx), accountID, request.Amount, request.Currency, request.Description, idempotencyKey)
 	case entity.TransactionTypeWithdrawal:
-		err = h.transactionService.HandleWithdraw(accountID, request.Amount, request.Description)
+		transaction, err = h.transactionService.HandleWithdraw(middleware.GetPrincipal(ctx), accountID, request.Amount, request.Currency, request.Description, idempotencyKey)
 	default:
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction type"})
 		return
@@ -72,15 +95,37 @@ func (h *Handler) HandleNewTransaction(ctx *gin.Context) {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
 			return
 		}
+		if respond.LimitExceeded(ctx, err) {
+			return
+		}
 		if err == entity.ErrInsufficientFunds {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds for withdrawal"})
 			return
 		}
+		if err == entity.ErrSystemAccount {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "System accounts cannot be used directly"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrCurrencyMismatch {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Currency does not match the account currency"})
+			return
+		}
+		if err == entity.ErrDuplicateIdempotencyKey {
+			h.replayConcurrentRequest(ctx, idempotencyKey)
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
 		log.Printf("Error processing transaction for account %d: %v", accountID, err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction"})
 		return
 	}
-	ctx.JSON(http.StatusCreated, gin.H{"message": "New transaction successful"})
+	h.respondTransaction(ctx, transaction)
 }
 
 func (h *Handler) HandleTransfer(ctx *gin.Context) {
@@ -90,13 +135,133 @@ func (h *Handler) HandleTransfer(ctx *gin.Context) {
 		return
 	}
 
-	if request.Amount.LessThanOrEqual(decimal.Zero) {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+	request.Currency = strings.ToUpper(request.Currency)
+	if !validateRequest(ctx, request.Validate()) {
+		return
+	}
+
+	idempotencyKey, ok := readIdempotencyKey(ctx, request)
+	if !ok {
+		return
+	}
+	if h.replayIdempotentRequest(ctx, idempotencyKey) {
+		return
+	}
+
+	transaction, err := h.transactionService.HandleTransfer(middleware.GetPrincipal(ctx), request.FromAccountID, request.ToAccountID, request.Amount, request.Currency, request.Convert, request.Description, idempotencyKey)
+	if err != nil {
+		if err == entity.ErrAccountNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "One or both accounts not found"})
+			return
+		}
+		if respond.LimitExceeded(ctx, err) {
+			return
+		}
+		if err == entity.ErrInsufficientFunds {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds for transfer"})
+			return
+		}
+		if err == entity.ErrSystemAccount {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "System accounts cannot be used directly"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrCurrencyMismatch {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Currency must match both accounts unless conversion is requested"})
+			return
+		}
+		if err == entity.ErrExchangeRateUnavailable {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Exchange rate unavailable for this currency pair"})
+			return
+		}
+		if err == entity.ErrConversionTooSmall {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount is too small to convert"})
+			return
+		}
+		if err == entity.ErrDuplicateIdempotencyKey {
+			h.replayConcurrentRequest(ctx, idempotencyKey)
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error processing transfer from account %d to %d: %v", request.FromAccountID, request.ToAccountID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transfer"})
+		return
+	}
+
+	h.respondTransaction(ctx, transaction)
+}
+
+// HandleBatchTransfer pays many wallets from one source wallet atomically, one transaction per transfer
+func (h *Handler) HandleBatchTransfer(ctx *gin.Context) {
+	var request entity.CreateBatchTransferRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	request.Currency = strings.ToUpper(request.Currency)
+	if !validateRequest(ctx, request.Validate()) {
+		return
+	}
+
+	idempotencyKey, ok := readIdempotencyKey(ctx, request)
+	if !ok {
+		return
+	}
+	if h.replayIdempotentRequest(ctx, idempotencyKey) {
+		return
+	}
+
+	response, err := h.transactionService.HandleBatchTransfer(middleware.GetPrincipal(ctx), request.FromAccountID, request.Transfers, request.Currency, request.Description, idempotencyKey)
+	if err != nil {
+		if err == entity.ErrAccountNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "One or more accounts not found"})
+			return
+		}
+		if respond.LimitExceeded(ctx, err) {
+			return
+		}
+		if err == entity.ErrInsufficientFunds {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds for batch transfer"})
+			return
+		}
+		if err == entity.ErrSystemAccount {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "System accounts cannot be used directly"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrCurrencyMismatch {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Currency must match every account"})
+			return
+		}
+		if err == entity.ErrDuplicateIdempotencyKey {
+			h.replayConcurrentRequest(ctx, idempotencyKey)
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error processing batch transfer from account %d: %v", request.FromAccountID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process batch transfer"})
 		return
 	}
 
-	if request.FromAccountID == request.ToAccountID {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Cannot transfer to the same account"})
+	ctx.JSON(http.StatusCreated, response)
+}
+
+// HandleMultiLegTransaction books an arbitrary list of balanced legs as a single transaction
+func (h *Handler) HandleMultiLegTransaction(ctx *gin.Context) {
+	var request entity.CreateMultiLegTransactionRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
 
@@ -110,20 +275,364 @@ func (h *Handler) HandleTransfer(ctx *gin.Context) {
 		return
 	}
 
-	err := h.transactionService.HandleTransfer(request.FromAccountID, request.ToAccountID, request.Amount, request.Description)
+	if len(request.Legs) < 2 || len(request.Legs) > maxTransactionLegs {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "A transaction must have between 2 and 100 legs"})
+		return
+	}
+
+	request.Currency = strings.ToUpper(request.Currency)
+	legs := make([]entity.LedgerLeg, 0, len(request.Legs))
+	for _, leg := range request.Legs {
+		if leg.Amount.LessThanOrEqual(decimal.Zero) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+			return
+		}
+		if !validateCurrencyAmount(ctx, request.Currency, leg.Amount) {
+			return
+		}
+		legs = append(legs, entity.LedgerLeg{
+			AccountID: leg.AccountID,
+			Amount:    leg.Amount,
+			Currency:  request.Currency,
+			IsCredit:  leg.IsCredit,
+		})
+	}
+
+	switch err := entity.ValidateLedgerLegs(legs); err {
+	case nil:
+	case entity.ErrTooFewLedgerLegs:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "A transaction must have between 2 and 100 legs"})
+		return
+	case entity.ErrNonPositiveLedgerLeg:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+		return
+	case entity.ErrDuplicateLedgerLeg:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Each account can only appear in one leg"})
+		return
+	case entity.ErrUnbalancedTransaction:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Credit legs must sum up to debit legs"})
+		return
+	default:
+		log.Printf("Error validating multi-leg transaction legs: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction"})
+		return
+	}
+
+	idempotencyKey, ok := readIdempotencyKey(ctx, request)
+	if !ok {
+		return
+	}
+	if h.replayIdempotentRequest(ctx, idempotencyKey) {
+		return
+	}
+
+	transaction, err := h.transactionService.HandleMultiLegTransaction(middleware.GetPrincipal(ctx), legs, request.Currency, request.Description, idempotencyKey)
 	if err != nil {
 		if err == entity.ErrAccountNotFound {
-			ctx.JSON(http.StatusNotFound, gin.H{"error": "One or both accounts not found"})
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "One or more accounts not found"})
+			return
+		}
+		if respond.LimitExceeded(ctx, err) {
 			return
 		}
 		if err == entity.ErrInsufficientFunds {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds for transfer"})
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds for transaction"})
 			return
 		}
-		log.Printf("Error processing transfer from account %d to %d: %v", request.FromAccountID, request.ToAccountID, err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transfer"})
+		if err == entity.ErrSystemAccount {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "System accounts cannot be used directly"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrCurrencyMismatch {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Currency must match every account"})
+			return
+		}
+		if err == entity.ErrDuplicateIdempotencyKey {
+			h.replayConcurrentRequest(ctx, idempotencyKey)
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error processing multi-leg transaction: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process transaction"})
+		return
+	}
+
+	h.respondTransaction(ctx, transaction)
+}
+
+// GetTransaction returns a transaction with its ledger legs
+func (h *Handler) GetTransaction(ctx *gin.Context) {
+	transactionIDStr := ctx.Param("id")
+	transactionID, err := strconv.ParseInt(transactionIDStr, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	transaction, err := h.transactionService.GetTransaction(middleware.GetPrincipal(ctx), transactionID)
+	if err != nil {
+		if err == entity.ErrTransactionNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this transaction is not allowed"})
+			return
+		}
+		log.Printf("Error getting transaction %d: %v", transactionID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transaction"})
+		return
+	}
+	ctx.JSON(http.StatusOK, transaction)
+}
+
+// HandleReversal reverses a transaction in full, or partially when an amount is given
+func (h *Handler) HandleReversal(ctx *gin.Context) {
+	var request entity.CreateReversalRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "Transfer successful"})
+	if request.Amount.IsNegative() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero"})
+		return
+	}
+
+	if len(request.Description) > 100 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Description must be less than 100 characters"})
+		return
+	}
+
+	transactionIDStr := ctx.Param("id")
+	transactionID, err := strconv.ParseInt(transactionIDStr, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transaction ID"})
+		return
+	}
+
+	idempotencyKey, ok := readIdempotencyKey(ctx, request)
+	if !ok {
+		return
+	}
+	if h.replayIdempotentRequest(ctx, idempotencyKey) {
+		return
+	}
+
+	transaction, err := h.transactionService.HandleReversal(middleware.GetPrincipal(ctx), transactionID, request.Amount, request.Description, idempotencyKey)
+	if err != nil {
+		if err == entity.ErrTransactionNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+			return
+		}
+		if err == entity.ErrCannotReverseReversal {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "A reversal cannot be reversed"})
+			return
+		}
+		if err == entity.ErrReversalExceedsTransaction {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Amount exceeds the remaining reversible amount of the transaction"})
+			return
+		}
+		if err == entity.ErrInvalidAmountScale {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Amount has more decimal places than the currency allows"})
+			return
+		}
+		if err == entity.ErrInsufficientFunds {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient funds for reversal"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrDuplicateIdempotencyKey {
+			h.replayConcurrentRequest(ctx, idempotencyKey)
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error reversing transaction %d: %v", transactionID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process reversal"})
+		return
+	}
+
+	h.respondTransaction(ctx, transaction)
+}
+
+// ImportTransactions applies a CSV file of deposits, withdrawals and transfers, sent either as the request body
+// or as the "file" field of a multipart form. The mode query param selects atomic (default) or per_row processing.
+func (h *Handler) ImportTransactions(ctx *gin.Context) {
+	atomic := true
+	switch ctx.DefaultQuery("mode", "atomic") {
+	case "atomic":
+	case "per_row":
+		atomic = false
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode, expected atomic or per_row"})
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImportFileSize)
+	var file io.Reader = ctx.Request.Body
+	if strings.HasPrefix(ctx.ContentType(), "multipart/") {
+		formFile, err := ctx.FormFile("file")
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Missing import file"})
+			return
+		}
+		opened, err := formFile.Open()
+		if err != nil {
+			log.Printf("Error opening import file: %v", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read import file"})
+			return
+		}
+		defer opened.Close()
+		file = opened
+	}
+
+	report, err := h.transactionService.ImportTransactions(middleware.GetPrincipal(ctx), file, atomic)
+	if err != nil {
+		if errors.Is(err, entity.ErrInvalidImportFile) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import file must be at most 10 MB"})
+			return
+		}
+		log.Printf("Error importing transactions: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import transactions"})
+		return
+	}
+
+	if atomic && report.Failed > 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, report)
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
+
+// validationMessages maps the errors of request validation to their response messages
+var validationMessages = map[error]string{
+	entity.ErrAmountNotPositive:      "Amount must be greater than zero",
+	entity.ErrSameAccountTransfer:    "Cannot transfer to the same account",
+	entity.ErrInvalidBatchSize:       "A batch must have between 1 and 1000 transfers",
+	entity.ErrDescriptionRequired:    "Description is required",
+	entity.ErrDescriptionTooLong:     "Description must be less than 100 characters",
+	entity.ErrUnsupportedCurrency:    "Unsupported currency",
+	entity.ErrInvalidAmountScale:     "Amount has more decimal places than the currency allows",
+	entity.ErrInvalidTransactionType: "Invalid transaction type",
+}
+
+// validateRequest writes a 400 response for a request validation error and returns false, or returns true when err is nil
+func validateRequest(ctx *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	message, ok := validationMessages[err]
+	if !ok {
+		message = "Invalid request body"
+	}
+	ctx.JSON(http.StatusBadRequest, gin.H{"error": message})
+	return false
+}
+
+// validateCurrencyAmount checks that the currency is supported and the amount fits its minor unit,
+// writing an error response and returning false otherwise
+func validateCurrencyAmount(ctx *gin.Context, currency string, amount decimal.Decimal) bool {
+	return validateRequest(ctx, entity.ValidateCurrencyAmount(currency, amount))
+}
+
+// readIdempotencyKey reads the optional Idempotency-Key header and fingerprints the request it guards,
+// so a replay can be told apart from a different request reusing the same key.
+// The fingerprint covers the caller, so another principal reusing a key never gets its original response.
+// It writes an error response and returns false when the header is invalid.
+func readIdempotencyKey(ctx *gin.Context, request interface{}) (entity.IdempotencyKey, bool) {
+	key := ctx.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		return entity.IdempotencyKey{}, true
+	}
+	if len(key) > 255 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency key must be at most 255 characters"})
+		return entity.IdempotencyKey{}, false
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		log.Printf("Error hashing request for idempotency key %q: %v", key, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return entity.IdempotencyKey{}, false
+	}
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Request.Method + " " + ctx.Request.URL.Path + "\n"))
+	hash.Write([]byte(middleware.GetPrincipal(ctx).Subject + "\n"))
+	hash.Write(payload)
+
+	return entity.IdempotencyKey{
+		Key:         key,
+		RequestHash: hex.EncodeToString(hash.Sum(nil)),
+	}, true
+}
+
+// replayIdempotentRequest writes the stored response of a request previously made with the same idempotency key.
+// It returns true when the request has been answered and must not be processed again.
+func (h *Handler) replayIdempotentRequest(ctx *gin.Context, idempotencyKey entity.IdempotencyKey) bool {
+	if idempotencyKey.Key == "" {
+		return false
+	}
+
+	record, err := h.transactionService.GetIdempotencyRecord(idempotencyKey.Key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false
+		}
+		log.Printf("Error getting idempotency record for key %q: %v", idempotencyKey.Key, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return true
+	}
+
+	if record.RequestHash != idempotencyKey.RequestHash {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency key was already used for a different request"})
+		return true
+	}
+	if !record.ResponseStatus.Valid {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "A request with this idempotency key is still being processed"})
+		return true
+	}
+
+	ctx.Header("Idempotent-Replayed", "true")
+	var transaction struct {
+		TransactionID int64 `json:"transaction_id"`
+	}
+	if json.Unmarshal(record.ResponseBody, &transaction) == nil && transaction.TransactionID != 0 {
+		ctx.Header("Location", transactionLocation(transaction.TransactionID))
+	}
+	ctx.Data(int(record.ResponseStatus.Int64), "application/json; charset=utf-8", record.ResponseBody)
+	return true
+}
+
+// replayConcurrentRequest answers a request that lost the race to a concurrent request with the same idempotency key
+func (h *Handler) replayConcurrentRequest(ctx *gin.Context, idempotencyKey entity.IdempotencyKey) {
+	if !h.replayIdempotentRequest(ctx, idempotencyKey) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "A request with this idempotency key is still being processed"})
+	}
+}
+
+// respondTransaction answers a request that created a transaction with the transaction and its location.
+// The service stored the response for replays along with the transaction when the request carries an idempotency key.
+func (h *Handler) respondTransaction(ctx *gin.Context, transaction entity.TransactionResponse) {
+	ctx.Header("Location", transactionLocation(transaction.TransactionID))
+	ctx.JSON(http.StatusCreated, transaction)
+}
+
+func transactionLocation(transactionID int64) string {
+	return "/transactions/" + strconv.FormatInt(transactionID, 10)
 }
@@ -0,0 +1,100 @@
+package wallet
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/middleware"
+)
+
+// GetWalletLimits returns the transaction limits of a wallet and the allowance it has left
+func (h *Handler) GetWalletLimits(ctx *gin.Context) {
+	accountID, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	limits, err := h.walletService.GetWalletLimits(middleware.GetPrincipal(ctx), accountID)
+	if err != nil {
+		respondLimitsError(ctx, err, "Error getting limits of account %d: %v", accountID)
+		return
+	}
+	ctx.JSON(http.StatusOK, limits)
+}
+
+// SetWalletLimits replaces the transaction limits of a wallet; null limits fall back to the defaults of its currency
+func (h *Handler) SetWalletLimits(ctx *gin.Context) {
+	accountID, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	var request entity.SetLimitsRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	limits, err := h.walletService.SetWalletLimits(middleware.GetPrincipal(ctx), accountID, request)
+	if err != nil {
+		respondLimitsError(ctx, err, "Error setting limits of account %d: %v", accountID)
+		return
+	}
+	ctx.JSON(http.StatusOK, limits)
+}
+
+// GetCurrencyLimits returns the default transaction limits of a currency
+func (h *Handler) GetCurrencyLimits(ctx *gin.Context) {
+	currency := strings.ToUpper(ctx.Param("currency"))
+	limits, err := h.walletService.GetCurrencyLimits(currency)
+	if err != nil {
+		respondLimitsError(ctx, err, "Error getting limits of currency %s: %v", currency)
+		return
+	}
+	ctx.JSON(http.StatusOK, limits)
+}
+
+// SetCurrencyLimits replaces the default transaction limits of a currency
+func (h *Handler) SetCurrencyLimits(ctx *gin.Context) {
+	currency := strings.ToUpper(ctx.Param("currency"))
+
+	var request entity.SetLimitsRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	limits, err := h.walletService.SetCurrencyLimits(currency, request)
+	if err != nil {
+		respondLimitsError(ctx, err, "Error setting limits of currency %s: %v", currency)
+		return
+	}
+	ctx.JSON(http.StatusOK, limits)
+}
+
+// respondLimitsError answers a failed limits request, logging the errors it does not expect
+func respondLimitsError(ctx *gin.Context, err error, logFormat string, subject interface{}) {
+	switch err {
+	case entity.ErrAccountNotFound:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+	case entity.ErrForbidden:
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+	case entity.ErrSystemAccount:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "System accounts cannot be used directly"})
+	case entity.ErrUnsupportedCurrency:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
+	case entity.ErrAmountNotPositive:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Limits must be greater than zero"})
+	case entity.ErrInvalidAmountScale:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Limit has more decimal places than the currency allows"})
+	default:
+		log.Printf(logFormat, subject, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process limits"})
+	}
+}
@@ -1,20 +1,44 @@
 package wallet
 
 import (
-	"database/sql"
+	"encoding/json"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/handler/respond"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/middleware"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+	historyDateLayout   = "2006-01-02"
+	maxStatusReasonLen  = 500
+	maxAccountNameLen   = 100
+	maxExternalRefLen   = 100
+	maxMetadataSize     = 16 << 10
 )
 
 type WalletServiceInterface interface {
-	CreateAccount(request entity.CreateAccountRequest) (entity.CreateAccountResponse, error)
-	GetBalance(accountID int64) (entity.GetBalanceResponse, error)
-	GetTransactionHistory(accountID int64, startDate, endDate string) (entity.TransactionListResponse, error)
+	CreateAccount(principal entity.Principal, request entity.CreateAccountRequest) (entity.WalletResponse, error)
+	GetWallet(principal entity.Principal, accountID int64) (entity.WalletResponse, error)
+	GetWalletByExternalRef(principal entity.Principal, externalRef string) (entity.WalletResponse, error)
+	UpdateWallet(principal entity.Principal, accountID int64, request entity.UpdateAccountRequest) (entity.WalletResponse, error)
+	GetBalanceAsOf(principal entity.Principal, accountID int64, asOf time.Time) (entity.GetBalanceAsOfResponse, error)
+	FreezeAccount(principal entity.Principal, accountID int64, blockCredits bool, reason string) (entity.AccountStatusResponse, error)
+	UnfreezeAccount(principal entity.Principal, accountID int64, reason string) (entity.AccountStatusResponse, error)
+	CloseAccount(principal entity.Principal, accountID int64, reason string) (entity.AccountStatusResponse, error)
+	GetTransactionHistory(principal entity.Principal, accountID int64, filter entity.TransactionFilter, page entity.HistoryPage) (entity.TransactionListResponse, error)
+	GetWalletLimits(principal entity.Principal, accountID int64) (entity.WalletLimitsResponse, error)
+	SetWalletLimits(principal entity.Principal, accountID int64, request entity.SetLimitsRequest) (entity.WalletLimitsResponse, error)
+	GetCurrencyLimits(currency string) (entity.CurrencyLimitsResponse, error)
+	SetCurrencyLimits(currency string, request entity.SetLimitsRequest) (entity.CurrencyLimitsResponse, error)
 }
 
 type Handler struct {
@@ -27,20 +51,132 @@ func NewHandler(walletService WalletServiceInterface) *Handler {
 	}
 }
 
-func (h *Handler) GetBalance(ctx *gin.Context) {
+// GetWallet returns the profile and balance of a wallet
+func (h *Handler) GetWallet(ctx *gin.Context) {
+	accountIDStr := ctx.Param("id")
+	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+	wallet, err := h.walletService.GetWallet(middleware.GetPrincipal(ctx), accountID)
+	if err != nil {
+		if err == entity.ErrAccountNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error getting wallet %d: %v", accountID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet"})
+		return
+	}
+	ctx.JSON(http.StatusOK, wallet)
+}
+
+// FindWallet looks a wallet up by the external_ref query param
+func (h *Handler) FindWallet(ctx *gin.Context) {
+	externalRef := ctx.Query("external_ref")
+	if externalRef == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "external_ref is required"})
+		return
+	}
+	wallet, err := h.walletService.GetWalletByExternalRef(middleware.GetPrincipal(ctx), externalRef)
+	if err != nil {
+		if err == entity.ErrAccountNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		log.Printf("Error finding wallet by external reference: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet"})
+		return
+	}
+	ctx.JSON(http.StatusOK, wallet)
+}
+
+// UpdateWallet changes the name, external reference or metadata of a wallet
+func (h *Handler) UpdateWallet(ctx *gin.Context) {
+	accountIDStr := ctx.Param("id")
+	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	var request entity.UpdateAccountRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if request.Name != nil && !validateAccountName(ctx, *request.Name) {
+		return
+	}
+	if request.ExternalRef != nil && !validateExternalRef(ctx, *request.ExternalRef) {
+		return
+	}
+	if request.Metadata != nil && !validateMetadata(ctx, request.Metadata) {
+		return
+	}
+
+	wallet, err := h.walletService.UpdateWallet(middleware.GetPrincipal(ctx), accountID, request)
+	if err != nil {
+		if err == entity.ErrAccountNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if err == entity.ErrSystemAccount {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "System accounts cannot be used directly"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrDuplicateExternalRef {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "External reference already used by another account"})
+			return
+		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error updating wallet %d: %v", accountID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wallet"})
+		return
+	}
+	ctx.JSON(http.StatusOK, wallet)
+}
+
+// GetBalanceAsOf returns the balance of a wallet at the as_of timestamp, or now when it is omitted
+func (h *Handler) GetBalanceAsOf(ctx *gin.Context) {
 	accountIDStr := ctx.Param("id")
 	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
 		return
 	}
-	balance, err := h.walletService.GetBalance(accountID)
+
+	asOf := time.Now().UTC()
+	if asOfStr := ctx.Query("as_of"); asOfStr != "" {
+		asOf, err = time.Parse(time.RFC3339, asOfStr)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid as_of format, expected RFC 3339"})
+			return
+		}
+	}
+
+	balance, err := h.walletService.GetBalanceAsOf(middleware.GetPrincipal(ctx), accountID, asOf)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == entity.ErrAccountNotFound {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
 			return
 		}
-		log.Printf("Error getting balance for account %d: %v", accountID, err)
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
+		log.Printf("Error getting balance of account %d as of %s: %v", accountID, asOf, err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get balance"})
 		return
 	}
@@ -55,55 +191,279 @@ func (h *Handler) GetTransactionHistory(ctx *gin.Context) {
 		return
 	}
 
-	startDate := ctx.Query("start_date")
-	endDate := ctx.Query("end_date")
-	if startDate != "" {
-		_, err := time.Parse("2006-01-02", startDate)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format, expected YYYY-MM-DD"})
+	filter, ok := parseTransactionFilter(ctx)
+	if !ok {
+		return
+	}
+
+	page := entity.HistoryPage{Limit: defaultHistoryLimit}
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit, expected a number between 1 and 200"})
 			return
 		}
+		page.Limit = limit
 	}
-	if endDate != "" {
-		_, err := time.Parse("2006-01-02", endDate)
+	if cursorStr := ctx.Query("cursor"); cursorStr != "" {
+		cursor, err := entity.DecodeHistoryCursor(cursorStr)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format, expected YYYY-MM-DD"})
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
 			return
 		}
+		page.Cursor = &cursor
 	}
 
-	transactionsResponse, err := h.walletService.GetTransactionHistory(accountID, startDate, endDate)
+	transactionsResponse, err := h.walletService.GetTransactionHistory(middleware.GetPrincipal(ctx), accountID, filter, page)
 	if err != nil {
 		if err == entity.ErrAccountNotFound {
 			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
 			return
 		}
+		if err == entity.ErrForbidden {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Access to this account is not allowed"})
+			return
+		}
 		log.Printf("Error getting transaction history for account %d: %v", accountID, err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transaction history"})
 		return
 	}
+	transactionsResponse.StartDate = ctx.Query("start_date")
+	transactionsResponse.EndDate = ctx.Query("end_date")
 	ctx.JSON(http.StatusOK, transactionsResponse)
 }
 
+// FreezeWallet blocks payouts from a wallet, and payments into it when block_credits is set
+func (h *Handler) FreezeWallet(ctx *gin.Context) {
+	h.changeWalletStatus(ctx, func(accountID int64, request entity.ChangeAccountStatusRequest) (entity.AccountStatusResponse, error) {
+		return h.walletService.FreezeAccount(middleware.GetPrincipal(ctx), accountID, request.BlockCredits, request.Reason)
+	})
+}
+
+// UnfreezeWallet lifts the freeze of a wallet
+func (h *Handler) UnfreezeWallet(ctx *gin.Context) {
+	h.changeWalletStatus(ctx, func(accountID int64, request entity.ChangeAccountStatusRequest) (entity.AccountStatusResponse, error) {
+		return h.walletService.UnfreezeAccount(middleware.GetPrincipal(ctx), accountID, request.Reason)
+	})
+}
+
+// CloseWallet closes a wallet with a zero balance for good
+func (h *Handler) CloseWallet(ctx *gin.Context) {
+	h.changeWalletStatus(ctx, func(accountID int64, request entity.ChangeAccountStatusRequest) (entity.AccountStatusResponse, error) {
+		return h.walletService.CloseAccount(middleware.GetPrincipal(ctx), accountID, request.Reason)
+	})
+}
+
+// changeWalletStatus validates a status change request and answers it with the outcome of change
+func (h *Handler) changeWalletStatus(ctx *gin.Context, change func(accountID int64, request entity.ChangeAccountStatusRequest) (entity.AccountStatusResponse, error)) {
+	accountIDStr := ctx.Param("id")
+	accountID, err := strconv.ParseInt(accountIDStr, 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	var request entity.ChangeAccountStatusRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+	if len(request.Reason) > maxStatusReasonLen {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Reason must be at most 500 characters"})
+		return
+	}
+
+	status, err := change(accountID, request)
+	if err != nil {
+		if err == entity.ErrAccountNotFound {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+		if err == entity.ErrSystemAccount {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "System accounts cannot be used directly"})
+			return
+		}
+		if respond.AccountStatus(ctx, err) {
+			return
+		}
+		if err == entity.ErrInvalidStatusTransition {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Account is not frozen"})
+			return
+		}
+		if err == entity.ErrAccountNotEmpty {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Account balance must be zero without active holds to close it"})
+			return
+		}
+		log.Printf("Error changing status of account %d: %v", accountID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change account status"})
+		return
+	}
+	ctx.JSON(http.StatusOK, status)
+}
+
 func (h *Handler) CreateWallet(ctx *gin.Context) {
 	var request entity.CreateAccountRequest
 	if err := ctx.ShouldBindJSON(&request); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
-	if request.Name == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Account name is required"})
+	if !validateAccountName(ctx, request.Name) {
 		return
 	}
-	if len(request.Name) > 100 {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Account name must be less than 100 characters"})
+	if !validateExternalRef(ctx, request.ExternalRef) {
+		return
+	}
+	if request.Metadata != nil && !validateMetadata(ctx, request.Metadata) {
+		return
+	}
+	request.Currency = strings.ToUpper(request.Currency)
+	if _, ok := entity.CurrencyScale(request.Currency); !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
 		return
 	}
-	account, err := h.walletService.CreateAccount(request)
+	account, err := h.walletService.CreateAccount(middleware.GetPrincipal(ctx), request)
 	if err != nil {
+		if err == entity.ErrDuplicateExternalRef {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "External reference already used by another account"})
+			return
+		}
 		log.Printf("Error creating account: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
 		return
 	}
 	ctx.JSON(http.StatusCreated, account)
 }
+
+// validateAccountName responds with 400 and returns false when the account name is empty or too long
+func validateAccountName(ctx *gin.Context, name string) bool {
+	if name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Account name is required"})
+		return false
+	}
+	if len(name) > maxAccountNameLen {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Account name must be less than 100 characters"})
+		return false
+	}
+	return true
+}
+
+// validateExternalRef responds with 400 and returns false when the external reference is too long
+func validateExternalRef(ctx *gin.Context, externalRef string) bool {
+	if len(externalRef) > maxExternalRefLen {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "External reference must be at most 100 characters"})
+		return false
+	}
+	return true
+}
+
+// validateMetadata responds with 400 and returns false unless the metadata is a JSON object of at most 16 KiB
+func validateMetadata(ctx *gin.Context, metadata json.RawMessage) bool {
+	var object map[string]interface{}
+	if err := json.Unmarshal(metadata, &object); err != nil || object == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Metadata must be a JSON object"})
+		return false
+	}
+	if len(metadata) > maxMetadataSize {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Metadata must be at most 16 KiB"})
+		return false
+	}
+	return true
+}
+
+// parseTransactionFilter reads the transaction history filters from the query params,
+// responding with 400 and returning false when one of them is invalid.
+// Dates are either YYYY-MM-DD, covering the whole UTC day, or RFC 3339 timestamps.
+func parseTransactionFilter(ctx *gin.Context) (entity.TransactionFilter, bool) {
+	var filter entity.TransactionFilter
+
+	if startDate := ctx.Query("start_date"); startDate != "" {
+		from, err := parseHistoryDate(startDate)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format, expected YYYY-MM-DD or RFC 3339"})
+			return filter, false
+		}
+		filter.From = from
+	}
+	if endDate := ctx.Query("end_date"); endDate != "" {
+		until, err := parseHistoryDate(endDate)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format, expected YYYY-MM-DD or RFC 3339"})
+			return filter, false
+		}
+		if len(endDate) == len(historyDateLayout) {
+			filter.Before = until.AddDate(0, 0, 1) // A date-only end date includes the whole day
+		} else {
+			filter.Until = until
+		}
+	}
+
+	if isCreditStr := ctx.Query("is_credit"); isCreditStr != "" {
+		isCredit, err := strconv.ParseBool(isCreditStr)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid is_credit, expected true or false"})
+			return filter, false
+		}
+		filter.IsCredit = &isCredit
+	}
+
+	if minAmountStr := ctx.Query("min_amount"); minAmountStr != "" {
+		minAmount, err := decimal.NewFromString(minAmountStr)
+		if err != nil || minAmount.IsNegative() {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_amount"})
+			return filter, false
+		}
+		filter.MinAmount = decimal.NewNullDecimal(minAmount)
+	}
+	if maxAmountStr := ctx.Query("max_amount"); maxAmountStr != "" {
+		maxAmount, err := decimal.NewFromString(maxAmountStr)
+		if err != nil || maxAmount.IsNegative() {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max_amount"})
+			return filter, false
+		}
+		filter.MaxAmount = decimal.NewNullDecimal(maxAmount)
+	}
+	if filter.MinAmount.Valid && filter.MaxAmount.Valid && filter.MinAmount.Decimal.GreaterThan(filter.MaxAmount.Decimal) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "min_amount must not be greater than max_amount"})
+		return filter, false
+	}
+
+	filter.Description = ctx.Query("description")
+	if len(filter.Description) > 255 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Description filter must be at most 255 characters"})
+		return filter, false
+	}
+
+	if transactionType := entity.TransactionType(ctx.Query("type")); transactionType != "" {
+		switch transactionType {
+		case entity.TransactionTypeDeposit, entity.TransactionTypeWithdrawal, entity.TransactionTypeTransfer, entity.TransactionTypeReversal:
+			filter.TransactionType = transactionType
+		default:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type, expected deposit, withdrawal, transfer or reversal"})
+			return filter, false
+		}
+	}
+
+	if counterpartyStr := ctx.Query("counterparty_id"); counterpartyStr != "" {
+		counterpartyID, err := strconv.ParseInt(counterpartyStr, 10, 64)
+		if err != nil || counterpartyID < 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid counterparty_id"})
+			return filter, false
+		}
+		filter.CounterpartyID = counterpartyID
+	}
+
+	return filter, true
+}
+
+// parseHistoryDate parses a YYYY-MM-DD date as midnight UTC, or an RFC 3339 timestamp
+func parseHistoryDate(value string) (time.Time, error) {
+	if len(value) == len(historyDateLayout) {
+		return time.Parse(historyDateLayout, value)
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,226 @@
+package jwt
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshInterval bounds how often a key set loaded from a URL is fetched again for an unknown key ID
+	refreshInterval = time.Minute
+	fetchTimeout    = 10 * time.Second
+	maxKeySetSize   = 1 << 20
+	// minRSAKeyBits is the smallest RSA modulus accepted, the minimum RS256 keys must have under RFC 7518
+	minRSAKeyBits = 2048
+)
+
+// verificationKey is a key of a key set: an *rsa.PublicKey, an *ecdsa.PublicKey on P-256 or an HMAC secret.
+// Alg restricts the key to one algorithm when the JWK names it.
+type verificationKey struct {
+	alg string
+	key interface{}
+}
+
+// jsonWebKey holds the members of a JWK (RFC 7517) used to build RSA, EC and symmetric verification keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// KeySet holds the verification keys of a JSON Web Key Set by key ID; keys without an ID are stored under "".
+// A key set loaded from a URL is fetched again when a token names an unknown key, at most once per refreshInterval,
+// so keys rotated by the issuer are picked up without a restart.
+type KeySet struct {
+	source string
+	client *http.Client
+
+	// refreshMu serializes refreshes so concurrent requests with an unknown key fetch the key set once
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	keys      map[string]verificationKey
+	fetchedAt time.Time
+}
+
+// LoadKeySet loads a JSON Web Key Set from an http(s) URL or a local file
+func LoadKeySet(source string) (*KeySet, error) {
+	set := &KeySet{
+		source: source,
+		client: &http.Client{Timeout: fetchTimeout},
+	}
+	if err := set.load(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func (s *KeySet) remote() bool {
+	return strings.HasPrefix(s.source, "https://") || strings.HasPrefix(s.source, "http://")
+}
+
+// key returns the key with the given ID, refreshing a remote key set when the ID is unknown
+func (s *KeySet) key(kid string) (verificationKey, bool) {
+	key, ok, stale := s.lookup(kid)
+	if ok || !stale || !s.remote() {
+		return key, ok
+	}
+
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	// Another request may have refreshed the key set while this one waited
+	key, ok, stale = s.lookup(kid)
+	if ok || !stale {
+		return key, ok
+	}
+	if err := s.load(); err != nil {
+		log.Printf("Error refreshing JSON Web Key Set from %s: %v", s.source, err)
+		return verificationKey{}, false
+	}
+	key, ok, _ = s.lookup(kid)
+	return key, ok
+}
+
+func (s *KeySet) lookup(kid string) (verificationKey, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[kid]
+	return key, ok, time.Since(s.fetchedAt) > refreshInterval
+}
+
+func (s *KeySet) load() error {
+	content, err := s.read()
+	if err != nil {
+		return err
+	}
+	keys, err := parseKeySet(content)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+func (s *KeySet) read() ([]byte, error) {
+	if !s.remote() {
+		return os.ReadFile(s.source)
+	}
+
+	response, err := s.client.Get(s.source)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching key set: unexpected status %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, maxKeySetSize))
+}
+
+// parseKeySet builds the verification keys of a JWKS document, skipping encryption keys and unsupported key types
+func parseKeySet(content []byte) (map[string]verificationKey, error) {
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("parsing key set: %w", err)
+	}
+
+	keys := make(map[string]verificationKey, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseKey(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("parsing key %q: %w", jwk.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		keys[jwk.Kid] = verificationKey{alg: jwk.Alg, key: key}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("key set has no usable signing key")
+	}
+	return keys, nil
+}
+
+func parseKey(jwk jsonWebKey) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA modulus of %d bits is shorter than %d bits", n.BitLen(), minRSAKeyBits)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		// The exponent must be an odd integer of at least 3 that fits in an int32, the largest crypto/rsa verifies with
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > math.MaxInt32 || e.Bit(0) == 0 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != 32 {
+			return nil, errors.New("invalid EC x coordinate")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil || len(y) != 32 {
+			return nil, errors.New("invalid EC y coordinate")
+		}
+		// crypto/ecdh rejects points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("invalid symmetric key")
+		}
+		return secret, nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(decoded) == 0 {
+		return nil, errors.New("invalid RSA key parameter")
+	}
+	return new(big.Int).SetBytes(decoded), nil
+}
@@ -0,0 +1,112 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+// modulus returns an odd number of the given bit length; parseKey only checks the size of the modulus
+func modulus(bits int) string {
+	n := new(big.Int).Lsh(big.NewInt(1), uint(bits-1))
+	return encodeBigInt(n.Add(n, big.NewInt(1)))
+}
+
+func TestParseKey(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := base64.RawURLEncoding.EncodeToString(ecdsaKey.X.FillBytes(make([]byte, 32)))
+	y := base64.RawURLEncoding.EncodeToString(ecdsaKey.Y.FillBytes(make([]byte, 32)))
+	offCurveY := base64.RawURLEncoding.EncodeToString(new(big.Int).Add(ecdsaKey.Y, big.NewInt(1)).FillBytes(make([]byte, 32)))
+	exponent := func(value int64) string {
+		return encodeBigInt(big.NewInt(value))
+	}
+
+	tests := []struct {
+		name    string
+		jwk     jsonWebKey
+		wantKey string
+		wantErr bool
+	}{
+		{"RSA 2048 bits", jsonWebKey{Kty: "RSA", N: modulus(2048), E: exponent(65537)}, "rsa", false},
+		{"RSA 4096 bits", jsonWebKey{Kty: "RSA", N: modulus(4096), E: exponent(3)}, "rsa", false},
+		{"RSA 2047 bits", jsonWebKey{Kty: "RSA", N: modulus(2047), E: exponent(65537)}, "", true},
+		{"RSA 1024 bits", jsonWebKey{Kty: "RSA", N: modulus(1024), E: exponent(65537)}, "", true},
+		{"RSA without modulus", jsonWebKey{Kty: "RSA", E: exponent(65537)}, "", true},
+		{"RSA exponent zero", jsonWebKey{Kty: "RSA", N: modulus(2048), E: "AA"}, "", true},
+		{"RSA exponent one", jsonWebKey{Kty: "RSA", N: modulus(2048), E: exponent(1)}, "", true},
+		{"RSA exponent even", jsonWebKey{Kty: "RSA", N: modulus(2048), E: exponent(65536)}, "", true},
+		{"RSA exponent beyond int32", jsonWebKey{Kty: "RSA", N: modulus(2048), E: exponent(1<<32 + 1)}, "", true},
+		{"RSA exponent beyond int64", jsonWebKey{Kty: "RSA", N: modulus(2048), E: encodeBigInt(new(big.Int).Lsh(big.NewInt(1), 64))}, "", true},
+		{"RSA without exponent", jsonWebKey{Kty: "RSA", N: modulus(2048)}, "", true},
+		{"EC P-256", jsonWebKey{Kty: "EC", Crv: "P-256", X: x, Y: y}, "ecdsa", false},
+		{"EC point off the curve", jsonWebKey{Kty: "EC", Crv: "P-256", X: x, Y: offCurveY}, "", true},
+		{"EC short coordinate", jsonWebKey{Kty: "EC", Crv: "P-256", X: x[:40], Y: y}, "", true},
+		{"EC P-384 skipped", jsonWebKey{Kty: "EC", Crv: "P-384", X: x, Y: y}, "", false},
+		{"symmetric", jsonWebKey{Kty: "oct", K: base64.RawURLEncoding.EncodeToString([]byte("secret"))}, "oct", false},
+		{"symmetric empty", jsonWebKey{Kty: "oct"}, "", true},
+		{"unknown key type skipped", jsonWebKey{Kty: "OKP"}, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := parseKey(test.jwk)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseKey() error = %v, want error %t", err, test.wantErr)
+			}
+			var got string
+			switch key.(type) {
+			case *rsa.PublicKey:
+				got = "rsa"
+			case *ecdsa.PublicKey:
+				got = "ecdsa"
+			case []byte:
+				got = "oct"
+			}
+			if got != test.wantKey {
+				t.Errorf("parseKey() key = %T, want %s", key, test.wantKey)
+			}
+		})
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	rsaKey := `{"kty": "RSA", "kid": "rsa", "n": "` + modulus(2048) + `", "e": "AQAB"}`
+	tests := []struct {
+		name     string
+		document string
+		wantKids []string
+		wantErr  bool
+	}{
+		{"signing key", `{"keys": [` + rsaKey + `]}`, []string{"rsa"}, false},
+		{"encryption key skipped", `{"keys": [` + rsaKey + `, {"kty": "oct", "kid": "enc", "use": "enc", "k": "c2VjcmV0"}]}`, []string{"rsa"}, false},
+		{"only encryption keys", `{"keys": [{"kty": "oct", "kid": "enc", "use": "enc", "k": "c2VjcmV0"}]}`, nil, true},
+		{"short RSA key fails the set", `{"keys": [` + rsaKey + `, {"kty": "RSA", "kid": "short", "n": "` + modulus(1024) + `", "e": "AQAB"}]}`, nil, true},
+		{"not JSON", `keys`, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := parseKeySet([]byte(test.document))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseKeySet() error = %v, want error %t", err, test.wantErr)
+			}
+			if len(keys) != len(test.wantKids) {
+				t.Fatalf("parseKeySet() = %d keys, want %v", len(keys), test.wantKids)
+			}
+			for _, kid := range test.wantKids {
+				if _, ok := keys[kid]; !ok {
+					t.Errorf("parseKeySet() lacks key %q", kid)
+				}
+			}
+		})
+	}
+}
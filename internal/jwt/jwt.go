@@ -0,0 +1,200 @@
+// Package jwt verifies JSON Web Tokens signed with RS256, ES256 or HS256 against a JSON Web Key Set.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// leeway tolerates clock skew between the token issuer and this service
+const leeway = time.Minute
+
+var (
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrInvalidSignature     = errors.New("invalid token signature")
+	ErrTokenExpired         = errors.New("token expired")
+	ErrTokenNotYetValid     = errors.New("token not yet valid")
+	ErrInvalidIssuer        = errors.New("unexpected token issuer")
+	ErrInvalidAudience      = errors.New("token not issued for this audience")
+	ErrMissingClaim         = errors.New("token lacks the sub or exp claim")
+)
+
+// Claims are the claims of a verified token that identify the caller
+type Claims struct {
+	Subject   string
+	Scopes    []string
+	ExpiresAt time.Time
+}
+
+// stringList decodes a claim that is either a single string or an array of strings, like aud and scp
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+type payload struct {
+	Subject   string     `json:"sub"`
+	Issuer    string     `json:"iss"`
+	Audience  stringList `json:"aud"`
+	ExpiresAt *float64   `json:"exp"`
+	NotBefore *float64   `json:"nbf"`
+	// Scope is the space-delimited scope claim of RFC 8693; some issuers use scp instead
+	Scope string     `json:"scope"`
+	Scp   stringList `json:"scp"`
+}
+
+// Verifier checks the signature and the registered claims of tokens.
+// Issuer and Audience are only checked when they are set.
+type Verifier struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+}
+
+func NewVerifier(keys *KeySet, issuer, audience string) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+// Verify returns the claims of a compact serialized token once its signature, expiry, issuer and audience are valid.
+// Tokens must carry the sub and exp claims.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	key, ok := v.keys.key(header.Kid)
+	if !ok {
+		return Claims{}, ErrUnknownKey
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return Claims{}, ErrUnsupportedAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformedToken
+	}
+	if err := verifySignature(header.Alg, key.key, parts[0]+"."+parts[1], signature); err != nil {
+		return Claims{}, err
+	}
+
+	var claims payload
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+	if claims.Subject == "" || claims.ExpiresAt == nil {
+		return Claims{}, ErrMissingClaim
+	}
+	now := time.Now()
+	expiresAt := time.Unix(int64(*claims.ExpiresAt), 0)
+	if now.After(expiresAt.Add(leeway)) {
+		return Claims{}, ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return Claims{}, ErrTokenNotYetValid
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return Claims{}, ErrInvalidIssuer
+	}
+	if v.audience != "" && !slices.Contains(claims.Audience, v.audience) {
+		return Claims{}, ErrInvalidAudience
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	for _, scp := range claims.Scp {
+		scopes = append(scopes, strings.Fields(scp)...)
+	}
+	return Claims{
+		Subject:   claims.Subject,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(decoded, v); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
+
+// verifySignature checks the signature of the signing input with the key, which must be of the type alg requires.
+// Matching the key type prevents a public key from being used as an HMAC secret.
+func verifySignature(alg string, key interface{}, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		// JWS encodes ECDSA signatures as the fixed-size concatenation of r and s
+		if len(signature) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.example"
+	testAudience = "wallet"
+)
+
+// signingKeys are the private keys signing the tokens of the tests, one per supported algorithm
+type signingKeys struct {
+	rsa    *rsa.PrivateKey
+	ecdsa  *ecdsa.PrivateKey
+	secret []byte
+}
+
+func newSigningKeys(t *testing.T) signingKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKeys{rsa: rsaKey, ecdsa: ecdsaKey, secret: []byte("a shared secret of 32 bytes.....")}
+}
+
+// keySet holds the public half of every signing key under the kid of its algorithm
+func (k signingKeys) keySet() *KeySet {
+	return &KeySet{
+		keys: map[string]verificationKey{
+			"rsa":   {alg: "RS256", key: &k.rsa.PublicKey},
+			"ec":    {alg: "ES256", key: &k.ecdsa.PublicKey},
+			"hmac":  {alg: "HS256", key: k.secret},
+			"noalg": {key: &k.rsa.PublicKey},
+		},
+		fetchedAt: time.Now(),
+	}
+}
+
+// sign signs the signing input with the key alg requires, leaving the signature empty for other algorithms
+func (k signingKeys) sign(t *testing.T, alg, signingInput string) []byte {
+	t.Helper()
+	digest := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case "RS256":
+		signature, err := rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ecdsa, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signingInput))
+		return mac.Sum(nil)
+	}
+	return nil
+}
+
+// token builds a compact serialized token, signing it with the key of signAlg
+func (k signingKeys) token(t *testing.T, alg, signAlg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(k.sign(t, signAlg, signingInput))
+}
+
+// tamper flips a bit in the last byte of the signature of a token
+func tamper(t *testing.T, token string) string {
+	t.Helper()
+	dot := len(token) - 1
+	for token[dot] != '.' {
+		dot--
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	if err != nil || len(signature) == 0 {
+		t.Fatalf("token has no signature to tamper with: %v", err)
+	}
+	signature[len(signature)-1] ^= 1
+	return token[:dot+1] + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	keys := newSigningKeys(t)
+	verifier := NewVerifier(keys.keySet(), testIssuer, testAudience)
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"sub":   "alice",
+			"iss":   testIssuer,
+			"aud":   testAudience,
+			"exp":   now.Add(time.Hour).Unix(),
+			"scope": "wallet:read wallet:write",
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+	// Claims are compared in whole seconds, so times a few seconds off the leeway stay on their side of it
+	const margin = 5 * time.Second
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"RS256", keys.token(t, "RS256", "RS256", "rsa", claims(nil)), nil},
+		{"RS256 tampered", tamper(t, keys.token(t, "RS256", "RS256", "rsa", claims(nil))), ErrInvalidSignature},
+		{"ES256", keys.token(t, "ES256", "ES256", "ec", claims(nil)), nil},
+		{"ES256 tampered", tamper(t, keys.token(t, "ES256", "ES256", "ec", claims(nil))), ErrInvalidSignature},
+		{"HS256", keys.token(t, "HS256", "HS256", "hmac", claims(nil)), nil},
+		{"HS256 tampered", tamper(t, keys.token(t, "HS256", "HS256", "hmac", claims(nil))), ErrInvalidSignature},
+		{"RS256 key without alg", keys.token(t, "RS256", "RS256", "noalg", claims(nil)), nil},
+		{"HS256 against an RSA key", keys.token(t, "HS256", "HS256", "rsa", claims(nil)), ErrUnsupportedAlgorithm},
+		{"HS256 against an RSA key without alg", keys.token(t, "HS256", "HS256", "noalg", claims(nil)), ErrUnsupportedAlgorithm},
+		{"ES256 against an HMAC key", keys.token(t, "ES256", "ES256", "hmac", claims(nil)), ErrUnsupportedAlgorithm},
+		{"alg none", keys.token(t, "none", "none", "noalg", claims(nil)), ErrUnsupportedAlgorithm},
+		{"alg none against a key with alg", keys.token(t, "none", "none", "rsa", claims(nil)), ErrUnsupportedAlgorithm},
+		{"unknown kid", keys.token(t, "RS256", "RS256", "retired", claims(nil)), ErrUnknownKey},
+		{"expired within the leeway", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-leeway + margin).Unix()})), nil},
+		{"expired beyond the leeway", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-leeway - margin).Unix()})), ErrTokenExpired},
+		{"not before within the leeway", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(leeway - margin).Unix()})), nil},
+		{"not before beyond the leeway", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(leeway + margin).Unix()})), ErrTokenNotYetValid},
+		{"wrong issuer", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"iss": "https://other.example"})), ErrInvalidIssuer},
+		{"missing issuer", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"iss": nil})), ErrInvalidIssuer},
+		{"wrong audience", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"aud": "billing"})), ErrInvalidAudience},
+		{"audience among several", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"aud": []string{"billing", testAudience}})), nil},
+		{"missing sub", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"sub": nil})), ErrMissingClaim},
+		{"missing exp", keys.token(t, "RS256", "RS256", "rsa", claims(map[string]interface{}{"exp": nil})), ErrMissingClaim},
+		{"two segments", "eyJhbGciOiJSUzI1NiJ9.e30", ErrMalformedToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := verifier.Verify(test.token)
+			if err != test.want {
+				t.Fatalf("Verify() error = %v, want %v", err, test.want)
+			}
+			if test.want == nil && claims.Subject != "alice" {
+				t.Errorf("Verify() subject = %q, want %q", claims.Subject, "alice")
+			}
+		})
+	}
+}
+
+type signatureTest struct {
+	name      string
+	alg       string
+	key       interface{}
+	signature []byte
+	want      error
+}
+
+func TestVerifySignature(t *testing.T) {
+	keys := newSigningKeys(t)
+	const signingInput = "header.payload"
+	publicKeys := map[string]interface{}{
+		"RS256": &keys.rsa.PublicKey,
+		"ES256": &keys.ecdsa.PublicKey,
+		"HS256": keys.secret,
+	}
+
+	tests := []signatureTest{
+		{"alg none", "none", publicKeys["RS256"], nil, ErrUnsupportedAlgorithm},
+		{"unknown alg", "RS512", publicKeys["RS256"], keys.sign(t, "RS256", signingInput), ErrUnsupportedAlgorithm},
+		{"ES256 signature of the wrong size", "ES256", publicKeys["ES256"], keys.sign(t, "ES256", signingInput)[:63], ErrInvalidSignature},
+		{"HS256 keyed with the RSA public key", "HS256", publicKeys["RS256"], keys.sign(t, "HS256", signingInput), ErrUnsupportedAlgorithm},
+	}
+	for _, alg := range []string{"RS256", "ES256", "HS256"} {
+		signature := keys.sign(t, alg, signingInput)
+		tampered := append([]byte(nil), signature...)
+		tampered[0] ^= 1
+		tests = append(tests,
+			signatureTest{alg + " valid", alg, publicKeys[alg], signature, nil},
+			signatureTest{alg + " tampered", alg, publicKeys[alg], tampered, ErrInvalidSignature},
+		)
+		for otherAlg, key := range publicKeys {
+			if otherAlg != alg {
+				tests = append(tests, signatureTest{alg + " with an " + otherAlg + " key", alg, key, signature, ErrUnsupportedAlgorithm})
+			}
+		}
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := verifySignature(test.alg, test.key, signingInput, test.signature); err != test.want {
+				t.Errorf("verifySignature() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
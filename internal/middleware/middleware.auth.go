@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+)
+
+const (
+	apiKeyHeader        = "X-API-Key"
+	authorizationHeader = "Authorization"
+	bearerScheme        = "Bearer "
+	principalKey        = "principal"
+)
+
+type AuthServiceInterface interface {
+	Authenticate(key string) (entity.Principal, error)
+	AuthenticateToken(token string) (entity.Principal, error)
+}
+
+// Authenticate rejects requests without a valid bearer token or API key with 401
+// and makes the authenticated principal available to the handlers through GetPrincipal.
+// A bearer token in the Authorization header takes precedence over the X-API-Key header.
+func Authenticate(authService AuthServiceInterface) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var principal entity.Principal
+		var err error
+		if authorization := ctx.GetHeader(authorizationHeader); authorization != "" {
+			// The authentication scheme is case-insensitive
+			if len(authorization) <= len(bearerScheme) || !strings.EqualFold(authorization[:len(bearerScheme)], bearerScheme) {
+				ctx.Header("WWW-Authenticate", "Bearer")
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unsupported authorization scheme, expected Bearer"})
+				return
+			}
+			principal, err = authService.AuthenticateToken(authorization[len(bearerScheme):])
+		} else if key := ctx.GetHeader(apiKeyHeader); key != "" {
+			principal, err = authService.Authenticate(key)
+		} else {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing API key or bearer token"})
+			return
+		}
+
+		if err != nil {
+			if err == entity.ErrInvalidAPIKey {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				return
+			}
+			if err == entity.ErrInvalidToken {
+				ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid bearer token"})
+				return
+			}
+			if err == entity.ErrTokenAuthDisabled {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Bearer tokens are not accepted"})
+				return
+			}
+			log.Printf("Error authenticating request: %v", err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate request"})
+			return
+		}
+
+		ctx.Set(principalKey, principal)
+		ctx.Next()
+	}
+}
+
+// RequireScope rejects requests of principals lacking scope with 403, logging the principal and the route
+func RequireScope(scope entity.Scope) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal := GetPrincipal(ctx)
+		if !principal.HasScope(scope) {
+			log.Printf("Denied %s %s to %q: missing scope %s", ctx.Request.Method, ctx.FullPath(), principal.Subject, scope)
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing scope " + string(scope)})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// GetPrincipal returns the principal authenticated by the Authenticate middleware.
+// Without one it returns the zero principal, which cannot access any account.
+func GetPrincipal(ctx *gin.Context) entity.Principal {
+	value, _ := ctx.Get(principalKey)
+	principal, _ := value.(entity.Principal)
+	return principal
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+)
+
+// likeEscaper escapes the wildcards of user input used in a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// conditions composes the WHERE clause of a query along with its numbered placeholders
+type conditions struct {
+	clauses []string
+	args    []interface{}
+}
+
+// add appends a condition whose %d verbs are replaced by the placeholders of args, in order
+func (c *conditions) add(condition string, args ...interface{}) {
+	placeholders := make([]interface{}, len(args))
+	for i, arg := range args {
+		placeholders[i] = c.placeholder(arg)
+	}
+	c.clauses = append(c.clauses, fmt.Sprintf(condition, placeholders...))
+}
+
+// placeholder binds an argument outside of the WHERE clause, e.g. for LIMIT, and returns its placeholder
+func (c *conditions) placeholder(arg interface{}) string {
+	c.args = append(c.args, arg)
+	return fmt.Sprintf("$%d", len(c.args))
+}
+
+func (c *conditions) String() string {
+	return strings.Join(c.clauses, " AND ")
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+)
+
+const accountQuery = `
+        SELECT b.account_id, a.name, a.currency, a.system_code, a.owner, a.status, a.block_credits,
+               a.external_ref, a.metadata, a.created_at, a.updated_at, ` + balanceColumn + `,
+               (SELECT COALESCE(SUM(h.amount), 0) FROM holds h
+                WHERE h.account_id = b.account_id AND h.status = 'active' AND h.expires_at > CURRENT_TIMESTAMP) AS held_amount
+        FROM accounts a
+        JOIN denormalized_balances b ON b.account_id = a.id`
+
+// GetAccount returns the profile and balance of an account
+func (r *Repository) GetAccount(accountID int64) (entity.Account, error) {
+	var account entity.Account
+	err := r.db.Get(&account, accountQuery+" WHERE a.id = $1", accountID)
+	if err != nil {
+		return account, err
+	}
+	return account, nil
+}
+
+// GetAccountByExternalRef returns the profile and balance of the account with the given external reference
+func (r *Repository) GetAccountByExternalRef(externalRef string) (entity.Account, error) {
+	var account entity.Account
+	err := r.db.Get(&account, accountQuery+" WHERE a.external_ref = $1", externalRef)
+	if err != nil {
+		return account, err
+	}
+	return account, nil
+}
+
+// UpdateAccount applies a partial profile update to an account.
+// A reused external reference is reported as entity.ErrDuplicateExternalRef.
+func (r *Repository) UpdateAccount(trx *sqlx.Tx, accountID int64, request entity.UpdateAccountRequest) error {
+	var metadata *string
+	if request.Metadata != nil {
+		value := string(request.Metadata)
+		metadata = &value
+	}
+
+	updateQuery := `
+        UPDATE accounts SET
+            name = COALESCE($1, name),
+            external_ref = CASE WHEN $2::text IS NULL THEN external_ref ELSE NULLIF($2::text, '') END,
+            metadata = COALESCE($3::jsonb, metadata),
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $4`
+	_, err := trx.Exec(updateQuery, request.Name, request.ExternalRef, metadata, accountID)
+	return externalRefError(err)
+}
+
+// externalRefError maps a violation of the external reference uniqueness to entity.ErrDuplicateExternalRef
+func externalRefError(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "unique_external_ref" {
+		return entity.ErrDuplicateExternalRef
+	}
+	return err
+}
+
+// UpdateAccountStatus moves an account to a new status and records the change with its reason.
+// Callers hold the account's balance lock, which serializes status changes with transactions.
+func (r *Repository) UpdateAccountStatus(trx *sqlx.Tx, accountID int64, from, to entity.AccountStatus, blockCredits bool, reason, changedBy string) error {
+	updateQuery := "UPDATE accounts SET status = $1, block_credits = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3"
+	_, err := trx.Exec(updateQuery, to, blockCredits, accountID)
+	if err != nil {
+		return err
+	}
+
+	eventQuery := `
+        INSERT INTO account_status_events (account_id, from_status, to_status, block_credits, reason, changed_by)
+        VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = trx.Exec(eventQuery, accountID, from, to, blockCredits, reason, changedBy)
+	return err
+}
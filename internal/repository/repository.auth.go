@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+)
+
+// CreateAPIKey stores a newly issued API key and returns its ID
+func (r *Repository) CreateAPIKey(apiKey entity.APIKey) (int64, error) {
+	query := "INSERT INTO api_keys (key_prefix, key_hash, subject, scopes) VALUES ($1, $2, $3, $4) RETURNING id"
+	var apiKeyID int64
+	err := r.db.QueryRow(query, apiKey.Prefix, apiKey.KeyHash, apiKey.Subject, apiKey.Scopes).Scan(&apiKeyID)
+	if err != nil {
+		return 0, err
+	}
+	return apiKeyID, nil
+}
+
+// GetAPIKeyByHash returns the unrevoked API key with the given hash
+func (r *Repository) GetAPIKeyByHash(keyHash string) (entity.APIKey, error) {
+	var apiKey entity.APIKey
+	query := `
+        SELECT id, key_prefix, key_hash, subject, scopes, created_at, revoked_at
+        FROM api_keys
+        WHERE key_hash = $1 AND revoked_at IS NULL`
+	err := r.db.Get(&apiKey, query, keyHash)
+	if err != nil {
+		return apiKey, err
+	}
+	return apiKey, nil
+}
+
+// RevokeAPIKey revokes an API key; revoking an unknown or already revoked key is reported as entity.ErrAPIKeyNotFound
+func (r *Repository) RevokeAPIKey(apiKeyID int64) error {
+	query := "UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL"
+	result, err := r.db.Exec(query, apiKeyID)
+	if err != nil {
+		return err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return entity.ErrAPIKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+)
+
+const feeScheduleColumns = "transaction_type, currency, fee_type, flat, rate, tiers, min_fee, max_fee, updated_at"
+
+// GetFeeSchedule returns the fee schedule of a transaction type and currency
+func (r *Repository) GetFeeSchedule(transactionType entity.TransactionType, currency string) (entity.FeeSchedule, error) {
+	var schedule entity.FeeSchedule
+	query := "SELECT " + feeScheduleColumns + " FROM fee_schedules WHERE transaction_type = $1 AND currency = $2"
+	err := r.db.Get(&schedule, query, transactionType, currency)
+	if err != nil {
+		return schedule, err
+	}
+	return schedule, nil
+}
+
+// GetFeeSchedules returns every fee schedule, ordered by transaction type and currency
+func (r *Repository) GetFeeSchedules() ([]entity.FeeSchedule, error) {
+	schedules := make([]entity.FeeSchedule, 0)
+	query := "SELECT " + feeScheduleColumns + " FROM fee_schedules ORDER BY transaction_type, currency"
+	err := r.db.Select(&schedules, query)
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// SetFeeSchedule replaces the fee schedule of a transaction type and currency
+func (r *Repository) SetFeeSchedule(schedule entity.FeeSchedule) error {
+	query := `
+        INSERT INTO fee_schedules (transaction_type, currency, fee_type, flat, rate, tiers, min_fee, max_fee)
+        VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $8)
+        ON CONFLICT (transaction_type, currency) DO UPDATE
+        SET fee_type = EXCLUDED.fee_type, flat = EXCLUDED.flat, rate = EXCLUDED.rate, tiers = EXCLUDED.tiers,
+            min_fee = EXCLUDED.min_fee, max_fee = EXCLUDED.max_fee, updated_at = CURRENT_TIMESTAMP`
+	_, err := r.db.Exec(query, schedule.TransactionType, schedule.Currency, schedule.FeeType, schedule.Flat, schedule.Rate,
+		schedule.Tiers, schedule.MinFee, schedule.MaxFee)
+	return err
+}
+
+// DeleteFeeSchedule removes the fee schedule of a transaction type and currency;
+// removing a schedule that does not exist is reported as entity.ErrFeeScheduleNotFound
+func (r *Repository) DeleteFeeSchedule(transactionType entity.TransactionType, currency string) error {
+	query := "DELETE FROM fee_schedules WHERE transaction_type = $1 AND currency = $2"
+	result, err := r.db.Exec(query, transactionType, currency)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return entity.ErrFeeScheduleNotFound
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package repository
 
 import (
+	"database/sql"
+
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
 	"github.com/shopspring/decimal"
 )
@@ -46,9 +49,30 @@ func (r *Repository) Rollback(tx *sqlx.Tx) error {
 	return tx.Rollback()
 }
 
-func (r *Repository) GetBalance(accountID int64) (decimal.Decimal, error) {
-	var balance decimal.Decimal
-	query := "SELECT balance FROM denormalized_balances WHERE account_id = $1"
+// balanceColumn selects the balance of account a, joined with its denormalized balance b.
+// System accounts are not kept in denormalized_balances (see insertLedger), so their balance is their latest
+// balance snapshot plus the legs booked after it: only the legs since the last snapshot run are summed.
+// Locked reads don't use it, see GetBalanceWithLock.
+const balanceColumn = `
+               CASE WHEN a.system_code IS NULL THEN b.balance
+                    ELSE COALESCE((SELECT s.balance FROM balance_snapshots s
+                                   WHERE s.account_id = a.id ORDER BY s.snapshot_at DESC LIMIT 1), 0)
+                       + COALESCE((SELECT SUM(CASE WHEN l.is_credit THEN -l.amount ELSE l.amount END)
+                                   FROM ledgers l
+                                   WHERE l.account_id = a.id
+                                     AND l.created_at > COALESCE((SELECT MAX(s.snapshot_at) FROM balance_snapshots s
+                                                                  WHERE s.account_id = a.id), '-infinity')), 0)
+               END AS balance`
+
+func (r *Repository) GetBalance(accountID int64) (entity.AccountBalance, error) {
+	var balance entity.AccountBalance
+	query := `
+        SELECT b.account_id, a.currency, a.system_code, a.owner, a.status, a.block_credits, ` + balanceColumn + `,
+               (SELECT COALESCE(SUM(h.amount), 0) FROM holds h
+                WHERE h.account_id = b.account_id AND h.status = 'active' AND h.expires_at > CURRENT_TIMESTAMP) AS held_amount
+        FROM denormalized_balances b
+        JOIN accounts a ON a.id = b.account_id
+        WHERE b.account_id = $1`
 	err := r.db.Get(&balance, query, accountID)
 	if err != nil {
 		return balance, err
@@ -56,9 +80,19 @@ func (r *Repository) GetBalance(accountID int64) (decimal.Decimal, error) {
 	return balance, nil
 }
 
-func (r *Repository) GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (decimal.Decimal, error) {
-	var balance decimal.Decimal
-	query := "SELECT balance FROM denormalized_balances WHERE account_id = $1 FOR UPDATE"
+// GetBalanceWithLock locks the denormalized balance of an account to book against it.
+// Transactions are never booked against the balance of a system account, so it is not computed here:
+// Balance is zero for system accounts, which callers reject.
+func (r *Repository) GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (entity.AccountBalance, error) {
+	var balance entity.AccountBalance
+	query := `
+        SELECT b.account_id, a.currency, a.system_code, a.owner, a.status, a.block_credits, b.balance,
+               (SELECT COALESCE(SUM(h.amount), 0) FROM holds h
+                WHERE h.account_id = b.account_id AND h.status = 'active' AND h.expires_at > CURRENT_TIMESTAMP) AS held_amount
+        FROM denormalized_balances b
+        JOIN accounts a ON a.id = b.account_id
+        WHERE b.account_id = $1
+        FOR UPDATE OF b`
 	err := trx.Get(&balance, query, accountID)
 	if err != nil {
 		return balance, err
@@ -66,12 +100,14 @@ func (r *Repository) GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (decimal.
 	return balance, nil
 }
 
-func (r *Repository) CreateAccount(trx *sqlx.Tx, accountName string) (int64, error) {
-	createAccountQuery := "INSERT INTO accounts (name) VALUES ($1) RETURNING id"
+// CreateAccount creates a customer account with a zero balance.
+// A reused external reference is reported as entity.ErrDuplicateExternalRef.
+func (r *Repository) CreateAccount(trx *sqlx.Tx, account entity.Account) (int64, error) {
+	createAccountQuery := "INSERT INTO accounts (name, currency, owner, external_ref, metadata) VALUES ($1, $2, $3, $4, $5::jsonb) RETURNING id"
 	var accountID int64
-	err := trx.QueryRow(createAccountQuery, accountName).Scan(&accountID)
+	err := trx.QueryRow(createAccountQuery, account.Name, account.Currency, account.Owner, account.ExternalRef, account.Metadata.String()).Scan(&accountID)
 	if err != nil {
-		return 0, err
+		return 0, externalRefError(err)
 	}
 
 	initBalanceQuery := "INSERT INTO denormalized_balances (account_id, balance) VALUES ($1, $2)"
@@ -93,117 +129,355 @@ func (r *Repository) CheckAccountExists(accountID int64) (bool, error) {
 	return exists, nil
 }
 
-func (r *Repository) CreateTransaction(trx *sqlx.Tx, accountID int64, amount decimal.Decimal, description string, isCredit bool) error {
-	createTransactionQuery := "INSERT INTO transactions (description) VALUES ($1) RETURNING id"
-	var transactionID int64
-	err := trx.QueryRow(createTransactionQuery, description).Scan(&transactionID)
+func (r *Repository) GetSystemAccountID(trx *sqlx.Tx, systemCode, currency string) (int64, error) {
+	var accountID int64
+	query := "SELECT id FROM accounts WHERE system_code = $1 AND currency = $2"
+	err := trx.Get(&accountID, query, systemCode, currency)
+	if err == nil {
+		return accountID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// System accounts are created on first use; a concurrent creation makes the insert a no-op
+	createAccountQuery := "INSERT INTO accounts (name, currency, system_code) VALUES ($1, $2, $3) ON CONFLICT (system_code, currency) DO NOTHING RETURNING id"
+	err = trx.QueryRow(createAccountQuery, systemCode+" "+currency, currency, systemCode).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		err = trx.Get(&accountID, query, systemCode, currency)
+		if err != nil {
+			return 0, err
+		}
+		return accountID, nil
+	}
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	initBalanceQuery := "INSERT INTO denormalized_balances (account_id, balance) VALUES ($1, $2)"
+	_, err = trx.Exec(initBalanceQuery, accountID, decimal.NewFromInt(0))
+	if err != nil {
+		return 0, err
 	}
 
-	createLedgerQuery := "INSERT INTO ledgers (transaction_id, account_id, amount, is_credit) VALUES ($1, $2, $3, $4)"
-	_, err = trx.Exec(createLedgerQuery, transactionID, accountID, amount, isCredit)
+	return accountID, nil
+}
+
+// insertTransaction creates the transactions row shared by all ledger legs of a transaction and returns it with its ID and date.
+// A reused idempotency key is reported as entity.ErrDuplicateIdempotencyKey.
+func (r *Repository) insertTransaction(trx *sqlx.Tx, transaction entity.Transaction, idempotencyKey entity.IdempotencyKey) (entity.Transaction, error) {
+	createTransactionQuery := `
+        INSERT INTO transactions (transaction_type, description, amount, currency, fx_rate, reversal_of, fee_amount, created_by, idempotency_key, request_hash)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''))
+        RETURNING id, transaction_date`
+	err := trx.QueryRow(createTransactionQuery, transaction.Type, transaction.Description, transaction.Amount, transaction.Currency,
+		transaction.FXRate, transaction.ReversalOf, transaction.Fee, transaction.CreatedBy, idempotencyKey.Key, idempotencyKey.RequestHash).Scan(&transaction.ID, &transaction.TransactionDate)
 	if err != nil {
-		return err
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "unique_idempotency_key" {
+			return entity.Transaction{}, entity.ErrDuplicateIdempotencyKey
+		}
+		return entity.Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// insertLedger appends a ledger leg to a transaction and applies it to the account's denormalized balance.
+// Credit legs decrease the balance, debit legs increase it.
+// System accounts take part in most transactions, so their balance is not kept denormalized: updating its row
+// would serialize every deposit and withdrawal. Their legs are only written to the ledger, without balance_after.
+func (r *Repository) insertLedger(trx *sqlx.Tx, transactionID int64, leg entity.LedgerLeg) (entity.Ledger, error) {
+	ledger := entity.Ledger{
+		TransactionID: transactionID,
+		AccountID:     leg.AccountID,
+		Amount:        leg.Amount,
+		IsCredit:      leg.IsCredit,
+	}
+
+	accountQuery := "SELECT currency, system_code FROM accounts WHERE id = $1"
+	err := trx.QueryRow(accountQuery, leg.AccountID).Scan(&ledger.Currency, &ledger.SystemCode)
+	if err != nil {
+		return entity.Ledger{}, err
 	}
 
-	if isCredit {
-		updateBalanceQuery := "UPDATE denormalized_balances SET balance = balance - $1 WHERE account_id = $2"
-		_, err = trx.Exec(updateBalanceQuery, amount, accountID)
+	// The balance row is locked by the caller, so the returned balance is the balance right after this leg
+	if !ledger.SystemCode.Valid {
+		amount := leg.Amount
+		if leg.IsCredit {
+			amount = amount.Neg()
+		}
+		updateBalanceQuery := "UPDATE denormalized_balances SET balance = balance + $1 WHERE account_id = $2 RETURNING balance"
+		err := trx.QueryRow(updateBalanceQuery, amount, leg.AccountID).Scan(&ledger.BalanceAfter)
 		if err != nil {
-			return err
+			return entity.Ledger{}, err
 		}
-	} else {
-		updateBalanceQuery := "UPDATE denormalized_balances SET balance = balance + $1 WHERE account_id = $2"
-		_, err = trx.Exec(updateBalanceQuery, amount, accountID)
+	}
+
+	createLedgerQuery := `
+        INSERT INTO ledgers (transaction_id, account_id, amount, is_credit, balance_after)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id`
+	err = trx.QueryRow(createLedgerQuery, transactionID, leg.AccountID, leg.Amount, leg.IsCredit, ledger.BalanceAfter).Scan(&ledger.ID)
+	if err != nil {
+		return entity.Ledger{}, err
+	}
+
+	return ledger, nil
+}
+
+// CreateTransaction books a transaction with the given ledger legs and returns the written transaction and ledger rows.
+// The written legs are checked to balance per currency, so an unbalanced transaction is never committed.
+func (r *Repository) CreateTransaction(trx *sqlx.Tx, transaction entity.Transaction, legs []entity.LedgerLeg, idempotencyKey entity.IdempotencyKey) (entity.Transaction, []entity.Ledger, error) {
+	transaction, err := r.insertTransaction(trx, transaction, idempotencyKey)
+	if err != nil {
+		return entity.Transaction{}, nil, err
+	}
+
+	ledgers := make([]entity.Ledger, 0, len(legs))
+	for _, leg := range legs {
+		ledger, err := r.insertLedger(trx, transaction.ID, leg)
 		if err != nil {
-			return err
+			return entity.Transaction{}, nil, err
 		}
+		ledgers = append(ledgers, ledger)
 	}
 
-	return nil
+	var unbalanced bool
+	checkBalancedQuery := `
+        SELECT EXISTS(
+            SELECT 1
+            FROM ledgers l
+            JOIN accounts a ON a.id = l.account_id
+            WHERE l.transaction_id = $1
+            GROUP BY a.currency
+            HAVING SUM(CASE WHEN l.is_credit THEN l.amount ELSE -l.amount END) <> 0
+        )`
+	err = trx.Get(&unbalanced, checkBalancedQuery, transaction.ID)
+	if err != nil {
+		return entity.Transaction{}, nil, err
+	}
+	if unbalanced {
+		return entity.Transaction{}, nil, entity.ErrUnbalancedTransaction
+	}
+
+	return transaction, ledgers, nil
 }
 
-func (r *Repository) CreateTransfer(trx *sqlx.Tx, fromAccountID, toAccountID int64, amount decimal.Decimal, description string) error {
-	createTransactionQuery := "INSERT INTO transactions (description) VALUES ($1) RETURNING id"
-	var transactionID int64
-	err := trx.QueryRow(createTransactionQuery, description).Scan(&transactionID)
+const transactionColumns = "id, transaction_type, transaction_date, description, amount, currency, fx_rate, reversal_of, fee_amount, created_by"
+
+func (r *Repository) GetTransaction(transactionID int64) (entity.Transaction, error) {
+	var transaction entity.Transaction
+	query := "SELECT " + transactionColumns + " FROM transactions WHERE id = $1"
+	err := r.db.Get(&transaction, query, transactionID)
 	if err != nil {
-		return err
+		return transaction, err
 	}
+	return transaction, nil
+}
 
-	createLedgerQuery := "INSERT INTO ledgers (transaction_id, account_id, amount, is_credit) VALUES ($1, $2, $3, $4)"
-	_, err = trx.Exec(createLedgerQuery, transactionID, fromAccountID, amount, true)
+// GetReversals returns the reversals of a transaction, oldest first
+func (r *Repository) GetReversals(transactionID int64) ([]entity.Transaction, error) {
+	reversals := make([]entity.Transaction, 0)
+	query := "SELECT " + transactionColumns + " FROM transactions WHERE reversal_of = $1 ORDER BY id"
+	err := r.db.Select(&reversals, query, transactionID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return reversals, nil
+}
 
-	_, err = trx.Exec(createLedgerQuery, transactionID, toAccountID, amount, false)
+func (r *Repository) GetTransactionWithLock(trx *sqlx.Tx, transactionID int64) (entity.Transaction, error) {
+	var transaction entity.Transaction
+	query := "SELECT " + transactionColumns + " FROM transactions WHERE id = $1 FOR UPDATE"
+	err := trx.Get(&transaction, query, transactionID)
 	if err != nil {
-		return err
+		return transaction, err
 	}
+	return transaction, nil
+}
+
+const transactionLedgersQuery = `
+        SELECT l.id, l.transaction_id, l.account_id, l.amount, l.is_credit, l.balance_after, a.currency, a.system_code, a.owner
+        FROM ledgers l
+        JOIN accounts a ON a.id = l.account_id
+        WHERE l.transaction_id = $1
+        ORDER BY l.id`
 
-	updateFromBalanceQuery := "UPDATE denormalized_balances SET balance = balance - $1 WHERE account_id = $2"
-	_, err = trx.Exec(updateFromBalanceQuery, amount, fromAccountID)
+// GetLedgers returns the ledger legs of a transaction
+func (r *Repository) GetLedgers(transactionID int64) ([]entity.Ledger, error) {
+	ledgers := make([]entity.Ledger, 0)
+	err := r.db.Select(&ledgers, transactionLedgersQuery, transactionID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return ledgers, nil
+}
 
-	updateToBalanceQuery := "UPDATE denormalized_balances SET balance = balance + $1 WHERE account_id = $2"
-	_, err = trx.Exec(updateToBalanceQuery, amount, toAccountID)
+func (r *Repository) GetTransactionLedgers(trx *sqlx.Tx, transactionID int64) ([]entity.Ledger, error) {
+	ledgers := make([]entity.Ledger, 0)
+	err := trx.Select(&ledgers, transactionLedgersQuery, transactionID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return ledgers, nil
+}
+
+// GetReversedAmounts returns how much of a transaction has been reversed so far,
+// both in total and per account of its ledger legs
+func (r *Repository) GetReversedAmounts(trx *sqlx.Tx, transactionID int64) (decimal.Decimal, map[int64]decimal.Decimal, error) {
+	var total decimal.Decimal
+	totalQuery := "SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE reversal_of = $1"
+	err := trx.Get(&total, totalQuery, transactionID)
+	if err != nil {
+		return total, nil, err
+	}
+
+	var rows []struct {
+		AccountID int64           `db:"account_id"`
+		Amount    decimal.Decimal `db:"amount"`
+	}
+	perAccountQuery := `
+        SELECT l.account_id, SUM(l.amount) AS amount
+        FROM transactions t
+        JOIN ledgers l ON t.id = l.transaction_id
+        WHERE t.reversal_of = $1
+        GROUP BY l.account_id`
+	err = trx.Select(&rows, perAccountQuery, transactionID)
+	if err != nil {
+		return total, nil, err
+	}
+
+	perAccount := make(map[int64]decimal.Decimal, len(rows))
+	for _, row := range rows {
+		perAccount[row.AccountID] = row.Amount
 	}
+	return total, perAccount, nil
+}
+
+func (r *Repository) GetIdempotencyRecord(idempotencyKey string) (entity.IdempotencyRecord, error) {
+	var record entity.IdempotencyRecord
+	query := "SELECT idempotency_key, request_hash, response_status, response_body FROM transactions WHERE idempotency_key = $1"
+	err := r.db.Get(&record, query, idempotencyKey)
+	if err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+// SaveIdempotentResponse stores the response of the request that created the transaction with the idempotency key
+func (r *Repository) SaveIdempotentResponse(trx *sqlx.Tx, idempotencyKey string, status int, body []byte) error {
+	query := "UPDATE transactions SET response_status = $1, response_body = $2, updated_at = CURRENT_TIMESTAMP WHERE idempotency_key = $3"
+	_, err := trx.Exec(query, status, string(body), idempotencyKey)
+	return err
+}
+
+// historyQuery reports the fee of a transaction on the payer's leg, which pays it out,
+// and the fee refunded by a reversal on the leg paying it back to the payer
+const historyQuery = `
+        SELECT t.id AS transaction_id, t.transaction_type, t.transaction_date, t.description,
+               l.id AS ledger_id, l.account_id, l.amount, a.currency, l.is_credit, l.balance_after,
+               CASE WHEN l.is_credit = (t.reversal_of IS NULL) THEN t.fee_amount ELSE 0 END AS fee_amount
+        FROM transactions t
+        JOIN ledgers l ON t.id = l.transaction_id
+        JOIN accounts a ON a.id = l.account_id
+        WHERE `
 
-	return nil
-}
-
-func (r *Repository) GetTransactionHistory(accountID int64, startDate, endDate string) ([]entity.TransactionDetail, error) {
-	var query string
-	var args []interface{}
-
-	if startDate != "" && endDate != "" {
-		query = `
-            SELECT t.id AS transaction_id, t.transaction_date, t.description, 
-                   l.id AS ledger_id, l.account_id, l.amount, l.is_credit
-            FROM transactions t
-            JOIN ledgers l ON t.id = l.transaction_id
-            WHERE l.account_id = $1 AND DATE(t.transaction_date) >= $2 AND DATE(t.transaction_date) <= $3
-            ORDER BY t.transaction_date DESC`
-		args = []interface{}{accountID, startDate, endDate}
-	} else if startDate != "" {
-		query = `
-            SELECT t.id AS transaction_id, t.transaction_date, t.description,
-                   l.id AS ledger_id, l.account_id, l.amount, l.is_credit
-            FROM transactions t
-            JOIN ledgers l ON t.id = l.transaction_id
-            WHERE l.account_id = $1 AND DATE(t.transaction_date) >= $2
-            ORDER BY t.transaction_date DESC`
-		args = []interface{}{accountID, startDate}
-	} else if endDate != "" {
-		query = `
-            SELECT t.id AS transaction_id, t.transaction_date, t.description,
-                   l.id AS ledger_id, l.account_id, l.amount, l.is_credit
-            FROM transactions t
-            JOIN ledgers l ON t.id = l.transaction_id
-            WHERE l.account_id = $1 AND DATE(t.transaction_date) <= $2
-            ORDER BY t.transaction_date DESC`
-		args = []interface{}{accountID, endDate}
-	} else {
-		query = `
-            SELECT t.id AS transaction_id, t.transaction_date, t.description,
-                   l.id AS ledger_id, l.account_id, l.amount, l.is_credit
-            FROM transactions t
-            JOIN ledgers l ON t.id = l.transaction_id
-            WHERE l.account_id = $1
-            ORDER BY t.transaction_date DESC`
-		args = []interface{}{accountID}
+// GetTransactionHistory returns a page of an account's ledger legs matching the filter, newest first,
+// fetching one row more than the page limit so callers can tell whether another page follows.
+// Ledger legs are written in the same database transaction as their transactions row, so l.created_at equals
+// t.transaction_date; filtering and ordering on it lets the query walk idx_ledgers_account_date.
+func (r *Repository) GetTransactionHistory(accountID int64, filter entity.TransactionFilter, page entity.HistoryPage) ([]entity.TransactionDetail, error) {
+	where := historyConditions(accountID, filter)
+	if page.Cursor != nil {
+		where.add("(l.created_at, l.id) < ($%d, $%d)", page.Cursor.TransactionDate, page.Cursor.LedgerID)
 	}
 
+	query := historyQuery + where.String() + `
+        ORDER BY l.created_at DESC, l.id DESC
+        LIMIT ` + where.placeholder(page.Limit+1)
+
 	transactions := make([]entity.TransactionDetail, 0)
-	err := r.db.Select(&transactions, query, args...)
+	err := r.db.Select(&transactions, query, where.args...)
 	if err != nil {
 		return nil, err
 	}
 	return transactions, nil
 }
+
+// StreamTransactionHistory calls fn with every ledger leg of an account matching the filter, oldest first,
+// reading rows one by one instead of loading the whole history. An error returned by fn stops the stream.
+func (r *Repository) StreamTransactionHistory(accountID int64, filter entity.TransactionFilter, fn func(entity.TransactionDetail) error) error {
+	where := historyConditions(accountID, filter)
+	query := historyQuery + where.String() + `
+        ORDER BY l.created_at, l.id`
+
+	rows, err := r.db.Queryx(query, where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transaction entity.TransactionDetail
+		err = rows.StructScan(&transaction)
+		if err != nil {
+			return err
+		}
+		err = fn(transaction)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetCounterparties returns the customer accounts on the legs of the given transactions, other than accountID
+func (r *Repository) GetCounterparties(accountID int64, transactionIDs []int64) ([]entity.Counterparty, error) {
+	query := `
+        SELECT l.transaction_id, l.account_id, a.name
+        FROM ledgers l
+        JOIN accounts a ON a.id = l.account_id
+        WHERE l.transaction_id = ANY($1) AND l.account_id <> $2 AND a.system_code IS NULL
+        ORDER BY l.transaction_id, l.id`
+
+	counterparties := make([]entity.Counterparty, 0)
+	err := r.db.Select(&counterparties, query, pq.Array(transactionIDs), accountID)
+	if err != nil {
+		return nil, err
+	}
+	return counterparties, nil
+}
+
+// historyConditions translates a transaction history filter into the conditions of a query over
+// transactions t joined with ledgers l
+func historyConditions(accountID int64, filter entity.TransactionFilter) *conditions {
+	where := &conditions{}
+	where.add("l.account_id = $%d", accountID)
+	if !filter.From.IsZero() {
+		where.add("l.created_at >= $%d", filter.From)
+	}
+	if !filter.Before.IsZero() {
+		where.add("l.created_at < $%d", filter.Before)
+	}
+	if !filter.Until.IsZero() {
+		where.add("l.created_at <= $%d", filter.Until)
+	}
+	if filter.IsCredit != nil {
+		where.add("l.is_credit = $%d", *filter.IsCredit)
+	}
+	if filter.MinAmount.Valid {
+		where.add("l.amount >= $%d", filter.MinAmount.Decimal)
+	}
+	if filter.MaxAmount.Valid {
+		where.add("l.amount <= $%d", filter.MaxAmount.Decimal)
+	}
+	if filter.Description != "" {
+		where.add("t.description ILIKE '%%' || $%d || '%%'", likeEscaper.Replace(filter.Description))
+	}
+	if filter.TransactionType != "" {
+		where.add("t.transaction_type = $%d", filter.TransactionType)
+	}
+	if filter.CounterpartyID != 0 {
+		where.add("EXISTS (SELECT 1 FROM ledgers c WHERE c.transaction_id = l.transaction_id AND c.id <> l.id AND c.account_id = $%d)", filter.CounterpartyID)
+	}
+	return where
+}
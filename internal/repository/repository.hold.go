@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// holdColumns reports holds past their expiry as expired, even before their stored status is updated
+const holdColumns = `
+        h.id, h.account_id, h.amount, a.currency, h.captured_amount,
+        CASE WHEN h.status = 'active' AND h.expires_at <= CURRENT_TIMESTAMP THEN 'expired' ELSE h.status END AS status,
+        h.description, h.expires_at, h.transaction_id, h.created_at, a.owner`
+
+// CreateHold inserts an active hold expiring expiresIn from now.
+// The expiry is computed from the database clock, the same clock holds are compared against when they are read.
+func (r *Repository) CreateHold(trx *sqlx.Tx, accountID int64, amount decimal.Decimal, description string, expiresIn time.Duration) (int64, error) {
+	query := `
+        INSERT INTO holds (account_id, amount, description, expires_at)
+        VALUES ($1, $2, $3, CURRENT_TIMESTAMP + $4 * INTERVAL '1 microsecond')
+        RETURNING id`
+	var holdID int64
+	err := trx.QueryRow(query, accountID, amount, description, expiresIn.Microseconds()).Scan(&holdID)
+	if err != nil {
+		return 0, err
+	}
+	return holdID, nil
+}
+
+func (r *Repository) GetHold(holdID int64) (entity.Hold, error) {
+	var hold entity.Hold
+	query := "SELECT" + holdColumns + `
+        FROM holds h
+        JOIN accounts a ON a.id = h.account_id
+        WHERE h.id = $1`
+	err := r.db.Get(&hold, query, holdID)
+	if err != nil {
+		return hold, err
+	}
+	return hold, nil
+}
+
+func (r *Repository) GetHoldWithLock(trx *sqlx.Tx, holdID int64) (entity.Hold, error) {
+	var hold entity.Hold
+	query := "SELECT" + holdColumns + `
+        FROM holds h
+        JOIN accounts a ON a.id = h.account_id
+        WHERE h.id = $1
+        FOR UPDATE OF h`
+	err := trx.Get(&hold, query, holdID)
+	if err != nil {
+		return hold, err
+	}
+	return hold, nil
+}
+
+func (r *Repository) UpdateHoldStatus(trx *sqlx.Tx, holdID int64, status entity.HoldStatus, capturedAmount decimal.NullDecimal, transactionID sql.NullInt64) error {
+	query := `
+        UPDATE holds
+        SET status = $1, captured_amount = $2, transaction_id = $3, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $4`
+	_, err := trx.Exec(query, status, capturedAmount, transactionID, holdID)
+	return err
+}
+
+// ExpireHolds stores the expired status on every active hold past its expiry and returns how many were updated
+func (r *Repository) ExpireHolds() (int64, error) {
+	query := `
+        UPDATE holds
+        SET status = 'expired', updated_at = CURRENT_TIMESTAMP
+        WHERE status = 'active' AND expires_at <= CURRENT_TIMESTAMP`
+	result, err := r.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
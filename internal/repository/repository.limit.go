@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// GetTransactionLimits returns the limits applied to an account, its own limits falling back to the defaults of its currency
+func (r *Repository) GetTransactionLimits(trx *sqlx.Tx, accountID int64, currency string) (entity.TransactionLimits, error) {
+	var limits entity.TransactionLimits
+	query := `
+        SELECT COALESCE(o.per_transaction, d.per_transaction) AS per_transaction,
+               COALESCE(o.daily, d.daily) AS daily
+        FROM (SELECT $1::int AS account_id, $2::text AS currency) a
+        LEFT JOIN transaction_limits o ON o.account_id = a.account_id
+        LEFT JOIN transaction_limits d ON d.currency = a.currency`
+	err := trx.Get(&limits, query, accountID, currency)
+	if err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+// GetAccountLimits returns the limits set on an account itself, without the defaults of its currency
+func (r *Repository) GetAccountLimits(trx *sqlx.Tx, accountID int64) (entity.TransactionLimits, error) {
+	var limits entity.TransactionLimits
+	query := `
+        SELECT o.per_transaction, o.daily
+        FROM (SELECT $1::int AS account_id) a
+        LEFT JOIN transaction_limits o ON o.account_id = a.account_id`
+	err := trx.Get(&limits, query, accountID)
+	if err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+// GetPaidOut returns the total an account paid out by withdrawals and outgoing transfers over the last 24 hours.
+// Reversals are not deducted, so a reversed payout still counts against the daily limit.
+func (r *Repository) GetPaidOut(trx *sqlx.Tx, accountID int64) (decimal.Decimal, error) {
+	var paidOut decimal.Decimal
+	query := `
+        SELECT COALESCE(SUM(l.amount), 0)
+        FROM ledgers l
+        JOIN transactions t ON t.id = l.transaction_id
+        WHERE l.account_id = $1 AND l.is_credit
+          AND t.transaction_type IN ('withdrawal', 'transfer')
+          AND l.created_at > CURRENT_TIMESTAMP - INTERVAL '24 hours'`
+	err := trx.Get(&paidOut, query, accountID)
+	if err != nil {
+		return paidOut, err
+	}
+	return paidOut, nil
+}
+
+// SetAccountLimits replaces the limits set on an account
+func (r *Repository) SetAccountLimits(trx *sqlx.Tx, accountID int64, limits entity.TransactionLimits) error {
+	query := `
+        INSERT INTO transaction_limits (account_id, per_transaction, daily) VALUES ($1, $2, $3)
+        ON CONFLICT (account_id) DO UPDATE
+        SET per_transaction = EXCLUDED.per_transaction, daily = EXCLUDED.daily, updated_at = CURRENT_TIMESTAMP`
+	_, err := trx.Exec(query, accountID, limits.PerTransaction, limits.Daily)
+	return err
+}
+
+// GetCurrencyLimits returns the default limits of a currency
+func (r *Repository) GetCurrencyLimits(currency string) (entity.TransactionLimits, error) {
+	var limits entity.TransactionLimits
+	query := `
+        SELECT d.per_transaction, d.daily
+        FROM (SELECT $1::text AS currency) c
+        LEFT JOIN transaction_limits d ON d.currency = c.currency`
+	err := r.db.Get(&limits, query, currency)
+	if err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+// SetCurrencyLimits replaces the default limits of a currency
+func (r *Repository) SetCurrencyLimits(currency string, limits entity.TransactionLimits) error {
+	query := `
+        INSERT INTO transaction_limits (currency, per_transaction, daily) VALUES ($1, $2, $3)
+        ON CONFLICT (currency) DO UPDATE
+        SET per_transaction = EXCLUDED.per_transaction, daily = EXCLUDED.daily, updated_at = CURRENT_TIMESTAMP`
+	_, err := r.db.Exec(query, currency, limits.PerTransaction, limits.Daily)
+	return err
+}
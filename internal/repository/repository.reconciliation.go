@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// CountBalances counts the denormalized balances of customer accounts, the only ones kept denormalized
+func (r *Repository) CountBalances() (int64, error) {
+	var count int64
+	query := "SELECT COUNT(*) FROM denormalized_balances b JOIN accounts a ON a.id = b.account_id WHERE a.system_code IS NULL"
+	err := r.db.Get(&count, query)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetBalanceDrifts recomputes every customer account balance from its ledger legs and
+// returns the accounts whose denormalized balance disagrees
+func (r *Repository) GetBalanceDrifts() ([]entity.BalanceDrift, error) {
+	query := `
+        SELECT b.account_id, a.currency, b.balance AS denormalized_balance,
+               COALESCE(l.balance, 0) AS ledger_balance,
+               b.balance - COALESCE(l.balance, 0) AS drift
+        FROM denormalized_balances b
+        JOIN accounts a ON a.id = b.account_id
+        LEFT JOIN (
+            SELECT account_id, SUM(CASE WHEN is_credit THEN -amount ELSE amount END) AS balance
+            FROM ledgers
+            GROUP BY account_id
+        ) l ON l.account_id = b.account_id
+        WHERE a.system_code IS NULL AND b.balance <> COALESCE(l.balance, 0)
+        ORDER BY b.account_id`
+	drifts := make([]entity.BalanceDrift, 0)
+	err := r.db.Select(&drifts, query)
+	if err != nil {
+		return nil, err
+	}
+	return drifts, nil
+}
+
+// GetLedgerImbalances sums the credit and debit legs of the whole ledger per currency, system accounts included,
+// and returns the currencies where they differ
+func (r *Repository) GetLedgerImbalances() ([]entity.LedgerImbalance, error) {
+	query := `
+        SELECT a.currency,
+               COALESCE(SUM(l.amount) FILTER (WHERE l.is_credit), 0) AS credits,
+               COALESCE(SUM(l.amount) FILTER (WHERE NOT l.is_credit), 0) AS debits,
+               SUM(CASE WHEN l.is_credit THEN l.amount ELSE -l.amount END) AS difference
+        FROM ledgers l
+        JOIN accounts a ON a.id = l.account_id
+        GROUP BY a.currency
+        HAVING SUM(CASE WHEN l.is_credit THEN l.amount ELSE -l.amount END) <> 0
+        ORDER BY a.currency`
+	imbalances := make([]entity.LedgerImbalance, 0)
+	err := r.db.Select(&imbalances, query)
+	if err != nil {
+		return nil, err
+	}
+	return imbalances, nil
+}
+
+// GetLedgerBalance sums the ledger legs of an account.
+// Callers hold the account's balance lock, so no legs can be added concurrently.
+func (r *Repository) GetLedgerBalance(trx *sqlx.Tx, accountID int64) (decimal.Decimal, error) {
+	var balance decimal.Decimal
+	query := "SELECT COALESCE(SUM(CASE WHEN is_credit THEN -amount ELSE amount END), 0) FROM ledgers WHERE account_id = $1"
+	err := trx.Get(&balance, query, accountID)
+	if err != nil {
+		return balance, err
+	}
+	return balance, nil
+}
+
+func (r *Repository) SetBalance(trx *sqlx.Tx, accountID int64, balance decimal.Decimal) error {
+	query := "UPDATE denormalized_balances SET balance = $1, updated_at = CURRENT_TIMESTAMP WHERE account_id = $2"
+	_, err := trx.Exec(query, balance, accountID)
+	return err
+}
+
+// RepairBalancesAfter recomputes the balance_after of every ledger leg of an account as the running sum of its legs
+// in booking order, and returns how many legs were corrected. Legs of an account are booked under its balance lock,
+// so their IDs follow the booking order.
+func (r *Repository) RepairBalancesAfter(trx *sqlx.Tx, accountID int64) (int64, error) {
+	query := `
+        UPDATE ledgers l
+        SET balance_after = running.balance_after, updated_at = CURRENT_TIMESTAMP
+        FROM (
+            SELECT id, SUM(CASE WHEN is_credit THEN -amount ELSE amount END) OVER (ORDER BY id) AS balance_after
+            FROM ledgers
+            WHERE account_id = $1
+        ) running
+        WHERE l.id = running.id AND l.balance_after IS DISTINCT FROM running.balance_after`
+	result, err := trx.Exec(query, accountID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
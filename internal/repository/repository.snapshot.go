@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+)
+
+// balanceAtQuery computes the balance of account $1 at instant $2 as its latest snapshot taken at or
+// before $2 plus the legs booked between the snapshot and $2, so old accounts don't sum their whole ledger
+const balanceAtQuery = `
+        COALESCE(s.balance, 0) + COALESCE((
+            SELECT SUM(CASE WHEN l.is_credit THEN -l.amount ELSE l.amount END)
+            FROM ledgers l
+            WHERE l.account_id = a.id AND l.created_at <= $1
+              AND (s.snapshot_at IS NULL OR l.created_at > s.snapshot_at)
+        ), 0)`
+
+const latestSnapshotJoin = `
+        LEFT JOIN LATERAL (
+            SELECT balance, snapshot_at
+            FROM balance_snapshots
+            WHERE account_id = a.id AND snapshot_at <= $1
+            ORDER BY snapshot_at DESC
+            LIMIT 1
+        ) s ON TRUE`
+
+// GetBalanceAsOf derives the balance of an account at the given instant from its ledger legs
+func (r *Repository) GetBalanceAsOf(accountID int64, asOf time.Time) (entity.AccountBalance, error) {
+	query := `
+        SELECT a.id AS account_id, a.currency, a.system_code, a.owner, ` + balanceAtQuery + ` AS balance
+        FROM accounts a` + latestSnapshotJoin + `
+        WHERE a.id = $2`
+	var balance entity.AccountBalance
+	err := r.db.Get(&balance, query, asOf, accountID)
+	if err != nil {
+		return entity.AccountBalance{}, err
+	}
+	return balance, nil
+}
+
+// CreateBalanceSnapshots records the balance of every account at the given instant,
+// building on each account's previous snapshot. Accounts already snapshotted at that instant are skipped.
+func (r *Repository) CreateBalanceSnapshots(snapshotAt time.Time) (int64, error) {
+	query := `
+        INSERT INTO balance_snapshots (account_id, balance, snapshot_at)
+        SELECT a.id, ` + balanceAtQuery + `, $1
+        FROM accounts a` + latestSnapshotJoin + `
+        ON CONFLICT (account_id, snapshot_at) DO NOTHING`
+	result, err := r.db.Exec(query, snapshotAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
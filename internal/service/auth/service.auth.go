@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/jwt"
+)
+
+const (
+	// apiKeyPrefix marks the keys issued by this service so they are easy to recognize, e.g. by secret scanners
+	apiKeyPrefix = "wk_"
+	// apiKeyBytes is the number of random bytes of a key
+	apiKeyBytes = 32
+	// displayPrefixLen is the number of leading characters of a key stored in clear to recognize it
+	displayPrefixLen = 11
+)
+
+type RepositoryInterface interface {
+	CreateAPIKey(apiKey entity.APIKey) (int64, error)
+	GetAPIKeyByHash(keyHash string) (entity.APIKey, error)
+	RevokeAPIKey(apiKeyID int64) error
+}
+
+// TokenVerifier verifies bearer tokens and returns their claims
+type TokenVerifier interface {
+	Verify(token string) (jwt.Claims, error)
+}
+
+type Service struct {
+	repository    RepositoryInterface
+	tokenVerifier TokenVerifier
+}
+
+// NewService creates the authentication service; bearer tokens are only accepted when tokenVerifier is not nil
+func NewService(repo RepositoryInterface, tokenVerifier TokenVerifier) *Service {
+	return &Service{
+		repository:    repo,
+		tokenVerifier: tokenVerifier,
+	}
+}
+
+// Authenticate resolves an API key to the principal it was issued to
+func (s *Service) Authenticate(key string) (entity.Principal, error) {
+	apiKey, err := s.repository.GetAPIKeyByHash(hashAPIKey(key))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.Principal{}, entity.ErrInvalidAPIKey
+		}
+		return entity.Principal{}, err
+	}
+	scopes := make([]entity.Scope, 0, len(apiKey.Scopes))
+	for _, scope := range apiKey.Scopes {
+		scopes = append(scopes, entity.Scope(scope))
+	}
+	return entity.Principal{
+		Subject: apiKey.Subject,
+		Scopes:  scopes,
+	}, nil
+}
+
+// AuthenticateToken resolves a bearer token to the principal named by its sub claim, with the scopes of the token.
+// The subject is prefixed with entity.TokenSubjectPrefix, keeping token principals apart from API key principals.
+// Scopes unknown to the service are ignored.
+func (s *Service) AuthenticateToken(token string) (entity.Principal, error) {
+	if s.tokenVerifier == nil {
+		return entity.Principal{}, entity.ErrTokenAuthDisabled
+	}
+	claims, err := s.tokenVerifier.Verify(token)
+	if err != nil {
+		log.Printf("Rejected bearer token: %v", err)
+		return entity.Principal{}, entity.ErrInvalidToken
+	}
+	subject := entity.TokenSubjectPrefix + claims.Subject
+	if len(subject) > entity.MaxSubjectLength {
+		log.Printf("Rejected bearer token: sub claim longer than %d characters", entity.MaxSubjectLength-len(entity.TokenSubjectPrefix))
+		return entity.Principal{}, entity.ErrInvalidToken
+	}
+	scopes := make([]entity.Scope, 0, len(claims.Scopes))
+	for _, scope := range claims.Scopes {
+		if entity.ValidScope(entity.Scope(scope)) {
+			scopes = append(scopes, entity.Scope(scope))
+		}
+	}
+	return entity.Principal{
+		Subject: subject,
+		Scopes:  scopes,
+	}, nil
+}
+
+// CreateAPIKey issues a new API key for subject granting the given scopes.
+// The key itself is only returned here and cannot be recovered later.
+// Subjects in the namespace of bearer tokens are rejected with entity.ErrInvalidSubject.
+func (s *Service) CreateAPIKey(subject string, scopes []entity.Scope) (string, entity.APIKey, error) {
+	if subject == "" || len(subject) > entity.MaxSubjectLength || strings.HasPrefix(subject, entity.TokenSubjectPrefix) {
+		return "", entity.APIKey{}, entity.ErrInvalidSubject
+	}
+
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", entity.APIKey{}, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	apiKey := entity.APIKey{
+		Prefix:  key[:displayPrefixLen],
+		KeyHash: hashAPIKey(key),
+		Subject: subject,
+		Scopes:  make(pq.StringArray, 0, len(scopes)),
+	}
+	for _, scope := range scopes {
+		apiKey.Scopes = append(apiKey.Scopes, string(scope))
+	}
+	apiKeyID, err := s.repository.CreateAPIKey(apiKey)
+	if err != nil {
+		return "", entity.APIKey{}, err
+	}
+	apiKey.ID = apiKeyID
+	return key, apiKey, nil
+}
+
+// RevokeAPIKey revokes an API key so it can no longer authenticate
+func (s *Service) RevokeAPIKey(apiKeyID int64) error {
+	return s.repository.RevokeAPIKey(apiKeyID)
+}
+
+// hashAPIKey returns the hex encoded SHA-256 hash under which a key is stored.
+// Keys are random enough that a fast unsalted hash cannot be brute forced.
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
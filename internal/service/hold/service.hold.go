@@ -0,0 +1,261 @@
+package hold
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+type RepositoryInterface interface {
+	Begin() (*sqlx.Tx, error)
+	Commit(tx *sqlx.Tx) error
+	Rollback(tx *sqlx.Tx) error
+	GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (entity.AccountBalance, error)
+	CreateTransaction(trx *sqlx.Tx, transaction entity.Transaction, legs []entity.LedgerLeg, idempotencyKey entity.IdempotencyKey) (entity.Transaction, []entity.Ledger, error)
+	GetSystemAccountID(trx *sqlx.Tx, systemCode, currency string) (int64, error)
+	CreateHold(trx *sqlx.Tx, accountID int64, amount decimal.Decimal, description string, expiresIn time.Duration) (int64, error)
+	GetHold(holdID int64) (entity.Hold, error)
+	GetHoldWithLock(trx *sqlx.Tx, holdID int64) (entity.Hold, error)
+	UpdateHoldStatus(trx *sqlx.Tx, holdID int64, status entity.HoldStatus, capturedAmount decimal.NullDecimal, transactionID sql.NullInt64) error
+	ExpireHolds() (int64, error)
+}
+
+// PayoutServiceInterface charges captures the withdrawal fee and checks them against the transaction limits
+// like withdrawals, implemented by the transaction service
+type PayoutServiceInterface interface {
+	FeeSchedule(transactionType entity.TransactionType, currency string) (entity.FeeSchedule, error)
+	AddFeeLeg(tx *sqlx.Tx, legs []entity.LedgerLeg, fee decimal.Decimal, currency string) ([]entity.LedgerLeg, error)
+	CheckLimits(tx *sqlx.Tx, balance entity.AccountBalance, amounts ...decimal.Decimal) error
+}
+
+type Service struct {
+	repository     RepositoryInterface
+	payouts        PayoutServiceInterface
+	systemAccounts entity.SystemAccounts
+}
+
+func NewService(repo RepositoryInterface, payouts PayoutServiceInterface, systemAccounts entity.SystemAccounts) *Service {
+	return &Service{
+		repository:     repo,
+		payouts:        payouts,
+		systemAccounts: systemAccounts,
+	}
+}
+
+// CreateHold reserves funds on an account, reducing its available balance without touching the ledger
+func (s *Service) CreateHold(principal entity.Principal, accountID int64, amount decimal.Decimal, currency, description string, expiresIn time.Duration) (entity.Hold, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	balance, err := s.repository.GetBalanceWithLock(tx, accountID)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	if !principal.CanAccess(balance.Owner) {
+		return entity.Hold{}, entity.ErrForbidden
+	}
+
+	if balance.SystemCode.Valid {
+		return entity.Hold{}, entity.ErrSystemAccount
+	}
+
+	// A hold reserves funds to be paid out, which a frozen account cannot do
+	if err := balance.CheckPayOut(); err != nil {
+		return entity.Hold{}, err
+	}
+
+	if balance.Currency != currency {
+		return entity.Hold{}, entity.ErrCurrencyMismatch
+	}
+
+	if balance.Available().LessThan(amount) {
+		return entity.Hold{}, entity.ErrInsufficientFunds
+	}
+
+	holdID, err := s.repository.CreateHold(tx, accountID, amount, description, expiresIn)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	return s.getHold(holdID)
+}
+
+// GetHold returns a hold of an account the principal may access
+func (s *Service) GetHold(principal entity.Principal, holdID int64) (entity.Hold, error) {
+	hold, err := s.getHold(holdID)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	if !principal.CanAccess(hold.Owner) {
+		return entity.Hold{}, entity.ErrForbidden
+	}
+	return hold, nil
+}
+
+func (s *Service) getHold(holdID int64) (entity.Hold, error) {
+	hold, err := s.repository.GetHold(holdID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.Hold{}, entity.ErrHoldNotFound
+		}
+		return entity.Hold{}, err
+	}
+	return hold, nil
+}
+
+// CaptureHold turns a hold into a withdrawal of the captured amount, charged the withdrawal fee on top of it.
+// A zero amount captures the full hold; any uncaptured remainder is released.
+func (s *Service) CaptureHold(principal entity.Principal, holdID int64, amount decimal.Decimal, description string) (entity.Hold, error) {
+	hold, err := s.GetHold(principal, holdID)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	schedule, err := s.payouts.FeeSchedule(entity.TransactionTypeWithdrawal, hold.Currency)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	// The balance is locked before the hold, the same order used when holds are created
+	balance, err := s.repository.GetBalanceWithLock(tx, hold.AccountID)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	hold, err = s.repository.GetHoldWithLock(tx, holdID)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	if hold.Status != entity.HoldStatusActive {
+		return entity.Hold{}, entity.ErrHoldNotActive
+	}
+
+	if amount.IsZero() {
+		amount = hold.Amount
+	}
+	if amount.GreaterThan(hold.Amount) {
+		return entity.Hold{}, entity.ErrCaptureExceedsHold
+	}
+	if err := entity.ValidateCurrencyAmount(hold.Currency, amount); err != nil {
+		return entity.Hold{}, err
+	}
+
+	if err := balance.CheckPayOut(); err != nil {
+		return entity.Hold{}, err
+	}
+
+	// The fee is debited from the account on top of the captured amount
+	fee := schedule.Fee(amount)
+	total := amount.Add(fee)
+
+	// Captures pay out like withdrawals, so they count against the transaction limits of the account
+	if err := s.payouts.CheckLimits(tx, balance, total); err != nil {
+		return entity.Hold{}, err
+	}
+
+	// This hold is already part of the held amount, so only other holds reduce what can be captured
+	if balance.Available().Add(hold.Amount).LessThan(total) {
+		return entity.Hold{}, entity.ErrInsufficientFunds
+	}
+
+	// Captures are booked like withdrawals, against the cash-out clearing account of the currency
+	clearingAccountID, err := s.repository.GetSystemAccountID(tx, s.systemAccounts.CashOut, hold.Currency)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	if description == "" {
+		description = fmt.Sprintf("Capture of hold %d", holdID)
+	}
+	legs := []entity.LedgerLeg{
+		{AccountID: hold.AccountID, Amount: total, Currency: hold.Currency, IsCredit: true},
+		{AccountID: clearingAccountID, Amount: amount, Currency: hold.Currency, IsCredit: false},
+	}
+	legs, err = s.payouts.AddFeeLeg(tx, legs, fee, hold.Currency)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	if err := entity.ValidateLedgerLegs(legs); err != nil {
+		return entity.Hold{}, err
+	}
+	transaction, _, err := s.repository.CreateTransaction(tx, entity.Transaction{
+		Type:        entity.TransactionTypeWithdrawal,
+		Description: description,
+		Amount:      amount,
+		Currency:    hold.Currency,
+		Fee:         fee,
+		CreatedBy:   principal.Subject,
+	}, legs, entity.IdempotencyKey{})
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	err = s.repository.UpdateHoldStatus(tx, holdID, entity.HoldStatusCaptured, decimal.NewNullDecimal(amount), sql.NullInt64{Int64: transaction.ID, Valid: true})
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	return s.getHold(holdID)
+}
+
+// VoidHold releases a hold without moving any funds
+func (s *Service) VoidHold(principal entity.Principal, holdID int64) (entity.Hold, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	hold, err := s.repository.GetHoldWithLock(tx, holdID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.Hold{}, entity.ErrHoldNotFound
+		}
+		return entity.Hold{}, err
+	}
+	if !principal.CanAccess(hold.Owner) {
+		return entity.Hold{}, entity.ErrForbidden
+	}
+	if hold.Status != entity.HoldStatusActive {
+		return entity.Hold{}, entity.ErrHoldNotActive
+	}
+
+	err = s.repository.UpdateHoldStatus(tx, holdID, entity.HoldStatusVoided, decimal.NullDecimal{}, sql.NullInt64{})
+	if err != nil {
+		return entity.Hold{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.Hold{}, err
+	}
+	return s.getHold(holdID)
+}
+
+// ExpireHolds marks the active holds past their expiry as expired.
+// Reads already report such holds as expired; this keeps the stored status in line for queries on the holds table.
+func (s *Service) ExpireHolds() (int64, error) {
+	return s.repository.ExpireHolds()
+}
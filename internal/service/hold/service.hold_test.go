@@ -0,0 +1,231 @@
+package hold
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/service/transaction"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	walletID      = 1
+	cashOutID     = 100
+	feeRevenueID  = 101
+	walletOwner   = "alice"
+	holdID        = 7
+	transactionID = 9
+)
+
+// fakeRepository serves one wallet with one active hold and records the transaction booked by a capture.
+// The embedded transaction repository is nil: the transaction service may only call the methods defined here.
+type fakeRepository struct {
+	transaction.RepositoryInterface
+	balance     entity.AccountBalance
+	hold        entity.Hold
+	schedule    *entity.FeeSchedule
+	limits      entity.TransactionLimits
+	paidOut     decimal.Decimal
+	transaction entity.Transaction
+	legs        []entity.LedgerLeg
+}
+
+func (r *fakeRepository) Begin() (*sqlx.Tx, error)   { return nil, nil }
+func (r *fakeRepository) Commit(tx *sqlx.Tx) error   { return nil }
+func (r *fakeRepository) Rollback(tx *sqlx.Tx) error { return nil }
+
+func (r *fakeRepository) GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (entity.AccountBalance, error) {
+	return r.balance, nil
+}
+
+func (r *fakeRepository) CreateTransaction(trx *sqlx.Tx, transaction entity.Transaction, legs []entity.LedgerLeg, idempotencyKey entity.IdempotencyKey) (entity.Transaction, []entity.Ledger, error) {
+	transaction.ID = transactionID
+	r.transaction, r.legs = transaction, legs
+	return transaction, nil, nil
+}
+
+func (r *fakeRepository) GetSystemAccountID(trx *sqlx.Tx, systemCode, currency string) (int64, error) {
+	if systemCode == entity.DefaultSystemAccounts.FeeRevenue {
+		return feeRevenueID, nil
+	}
+	return cashOutID, nil
+}
+
+func (r *fakeRepository) GetFeeSchedule(transactionType entity.TransactionType, currency string) (entity.FeeSchedule, error) {
+	if r.schedule == nil || r.schedule.TransactionType != transactionType || r.schedule.Currency != currency {
+		return entity.FeeSchedule{}, sql.ErrNoRows
+	}
+	return *r.schedule, nil
+}
+
+func (r *fakeRepository) GetTransactionLimits(trx *sqlx.Tx, accountID int64, currency string) (entity.TransactionLimits, error) {
+	return r.limits, nil
+}
+
+func (r *fakeRepository) GetPaidOut(trx *sqlx.Tx, accountID int64) (decimal.Decimal, error) {
+	return r.paidOut, nil
+}
+
+func (r *fakeRepository) CreateHold(trx *sqlx.Tx, accountID int64, amount decimal.Decimal, description string, expiresIn time.Duration) (int64, error) {
+	return holdID, nil
+}
+
+func (r *fakeRepository) GetHold(holdID int64) (entity.Hold, error) {
+	return r.hold, nil
+}
+
+func (r *fakeRepository) GetHoldWithLock(trx *sqlx.Tx, holdID int64) (entity.Hold, error) {
+	return r.hold, nil
+}
+
+func (r *fakeRepository) UpdateHoldStatus(trx *sqlx.Tx, holdID int64, status entity.HoldStatus, capturedAmount decimal.NullDecimal, transactionID sql.NullInt64) error {
+	r.hold.Status, r.hold.CapturedAmount = status, capturedAmount
+	return nil
+}
+
+func (r *fakeRepository) ExpireHolds() (int64, error) {
+	return 0, nil
+}
+
+func d(value string) decimal.Decimal {
+	return decimal.RequireFromString(value)
+}
+
+func TestCaptureHold(t *testing.T) {
+	flatFee := &entity.FeeSchedule{TransactionType: entity.TransactionTypeWithdrawal, Currency: "USD", FeeType: entity.FeeTypeFlat, Flat: d("1")}
+	percentageFee := &entity.FeeSchedule{TransactionType: entity.TransactionTypeWithdrawal, Currency: "USD", FeeType: entity.FeeTypePercentage, Rate: d("0.01")}
+	transferFee := &entity.FeeSchedule{TransactionType: entity.TransactionTypeTransfer, Currency: "USD", FeeType: entity.FeeTypeFlat, Flat: d("1")}
+
+	tests := []struct {
+		name     string
+		balance  string
+		schedule *entity.FeeSchedule
+		amount   string
+		want     error
+		wantFee  string
+		wantLegs []entity.LedgerLeg
+	}{
+		{
+			name: "without fee schedule", balance: "100", amount: "50", wantFee: "0",
+			wantLegs: []entity.LedgerLeg{
+				{AccountID: walletID, Amount: d("50"), Currency: "USD", IsCredit: true},
+				{AccountID: cashOutID, Amount: d("50"), Currency: "USD"},
+			},
+		},
+		{
+			name: "charged the withdrawal fee", balance: "100", schedule: flatFee, amount: "50", wantFee: "1",
+			wantLegs: []entity.LedgerLeg{
+				{AccountID: walletID, Amount: d("51"), Currency: "USD", IsCredit: true},
+				{AccountID: cashOutID, Amount: d("50"), Currency: "USD"},
+				{AccountID: feeRevenueID, Amount: d("1"), Currency: "USD"},
+			},
+		},
+		{
+			name: "not charged the transfer fee", balance: "100", schedule: transferFee, amount: "50", wantFee: "0",
+			wantLegs: []entity.LedgerLeg{
+				{AccountID: walletID, Amount: d("50"), Currency: "USD", IsCredit: true},
+				{AccountID: cashOutID, Amount: d("50"), Currency: "USD"},
+			},
+		},
+		{
+			name: "partial capture charged on the captured amount", balance: "100", schedule: percentageFee, amount: "20", wantFee: "0.2",
+			wantLegs: []entity.LedgerLeg{
+				{AccountID: walletID, Amount: d("20.2"), Currency: "USD", IsCredit: true},
+				{AccountID: cashOutID, Amount: d("20"), Currency: "USD"},
+				{AccountID: feeRevenueID, Amount: d("0.2"), Currency: "USD"},
+			},
+		},
+		{
+			name: "fee paid from the rest of the hold", balance: "50", schedule: flatFee, amount: "49", wantFee: "1",
+			wantLegs: []entity.LedgerLeg{
+				{AccountID: walletID, Amount: d("50"), Currency: "USD", IsCredit: true},
+				{AccountID: cashOutID, Amount: d("49"), Currency: "USD"},
+				{AccountID: feeRevenueID, Amount: d("1"), Currency: "USD"},
+			},
+		},
+		{name: "fee beyond the available balance", balance: "50.5", schedule: flatFee, amount: "50", want: entity.ErrInsufficientFunds},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owner := sql.NullString{String: walletOwner, Valid: true}
+			repo := &fakeRepository{
+				balance:  entity.AccountBalance{AccountID: walletID, Currency: "USD", Owner: owner, Status: entity.AccountStatusActive, Balance: d(test.balance), HeldAmount: d("50")},
+				hold:     entity.Hold{HoldID: holdID, AccountID: walletID, Amount: d("50"), Currency: "USD", Status: entity.HoldStatusActive, Owner: owner},
+				schedule: test.schedule,
+			}
+			payouts := transaction.NewService(repo, transaction.Config{SystemAccounts: entity.DefaultSystemAccounts})
+			service := NewService(repo, payouts, entity.DefaultSystemAccounts)
+
+			_, err := service.CaptureHold(entity.Principal{Subject: walletOwner}, holdID, d(test.amount), "")
+			if err != test.want {
+				t.Fatalf("CaptureHold() error = %v, want %v", err, test.want)
+			}
+			if test.want != nil {
+				if repo.legs != nil {
+					t.Errorf("CaptureHold() booked %v after failing", repo.legs)
+				}
+				return
+			}
+
+			if !repo.transaction.Amount.Equal(d(test.amount)) || !repo.transaction.Fee.Equal(d(test.wantFee)) {
+				t.Errorf("transaction amount %s fee %s, want %s fee %s", repo.transaction.Amount, repo.transaction.Fee, test.amount, test.wantFee)
+			}
+			if len(repo.legs) != len(test.wantLegs) {
+				t.Fatalf("legs = %v, want %v", repo.legs, test.wantLegs)
+			}
+			for i, leg := range repo.legs {
+				want := test.wantLegs[i]
+				if leg.AccountID != want.AccountID || !leg.Amount.Equal(want.Amount) || leg.Currency != want.Currency || leg.IsCredit != want.IsCredit {
+					t.Errorf("leg %d = %v, want %v", i, leg, want)
+				}
+			}
+			if repo.hold.Status != entity.HoldStatusCaptured {
+				t.Errorf("hold status = %s, want %s", repo.hold.Status, entity.HoldStatusCaptured)
+			}
+		})
+	}
+}
+
+func TestCaptureHoldLimits(t *testing.T) {
+	nd := func(value string) decimal.NullDecimal {
+		return decimal.NewNullDecimal(d(value))
+	}
+	tests := []struct {
+		name    string
+		limits  entity.TransactionLimits
+		paidOut string
+		want    entity.LimitType
+	}{
+		{"per transaction limit fits the fee", entity.TransactionLimits{PerTransaction: nd("51")}, "0", ""},
+		{"per transaction limit counts the fee", entity.TransactionLimits{PerTransaction: nd("50.99")}, "0", entity.LimitTypePerTransaction},
+		{"daily limit fits the fee", entity.TransactionLimits{Daily: nd("100")}, "49", ""},
+		{"daily limit counts the fee", entity.TransactionLimits{Daily: nd("100")}, "49.01", entity.LimitTypeDaily},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owner := sql.NullString{String: walletOwner, Valid: true}
+			repo := &fakeRepository{
+				balance:  entity.AccountBalance{AccountID: walletID, Currency: "USD", Owner: owner, Status: entity.AccountStatusActive, Balance: d("100"), HeldAmount: d("50")},
+				hold:     entity.Hold{HoldID: holdID, AccountID: walletID, Amount: d("50"), Currency: "USD", Status: entity.HoldStatusActive, Owner: owner},
+				schedule: &entity.FeeSchedule{TransactionType: entity.TransactionTypeWithdrawal, Currency: "USD", FeeType: entity.FeeTypeFlat, Flat: d("1")},
+				limits:   test.limits,
+				paidOut:  d(test.paidOut),
+			}
+			payouts := transaction.NewService(repo, transaction.Config{SystemAccounts: entity.DefaultSystemAccounts})
+			service := NewService(repo, payouts, entity.DefaultSystemAccounts)
+
+			_, err := service.CaptureHold(entity.Principal{Subject: walletOwner}, holdID, d("50"), "")
+			var exceeded *entity.LimitExceededError
+			switch {
+			case test.want == "" && err != nil:
+				t.Fatalf("CaptureHold() error = %v, want nil", err)
+			case test.want != "" && (!errors.As(err, &exceeded) || exceeded.Limit != test.want):
+				t.Fatalf("CaptureHold() error = %v, want %s limit exceeded", err, test.want)
+			}
+		})
+	}
+}
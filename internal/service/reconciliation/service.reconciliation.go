@@ -0,0 +1,119 @@
+package reconciliation
+
+import (
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+type RepositoryInterface interface {
+	Begin() (*sqlx.Tx, error)
+	Commit(tx *sqlx.Tx) error
+	Rollback(tx *sqlx.Tx) error
+	GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (entity.AccountBalance, error)
+	CountBalances() (int64, error)
+	GetBalanceDrifts() ([]entity.BalanceDrift, error)
+	GetLedgerImbalances() ([]entity.LedgerImbalance, error)
+	GetLedgerBalance(trx *sqlx.Tx, accountID int64) (decimal.Decimal, error)
+	SetBalance(trx *sqlx.Tx, accountID int64, balance decimal.Decimal) error
+	RepairBalancesAfter(trx *sqlx.Tx, accountID int64) (int64, error)
+}
+
+type Service struct {
+	repository RepositoryInterface
+}
+
+func NewService(repo RepositoryInterface) *Service {
+	return &Service{
+		repository: repo,
+	}
+}
+
+// Reconcile compares every denormalized balance with the sum of the account's ledger legs, and checks that the
+// credits of the whole ledger equal its debits in every currency, which also covers the system accounts.
+// With repair set, drifted balances are reset to their ledger balance under the account's balance lock,
+// along with the balance_after of the account's ledger legs booked from the drifted balance.
+// An imbalanced ledger is only reported: no single balance can be reset to fix it.
+func (s *Service) Reconcile(repair bool) (entity.ReconciliationReport, error) {
+	checkedAt := time.Now()
+	accountsChecked, err := s.repository.CountBalances()
+	if err != nil {
+		return entity.ReconciliationReport{}, err
+	}
+
+	drifts, err := s.repository.GetBalanceDrifts()
+	if err != nil {
+		return entity.ReconciliationReport{}, err
+	}
+
+	imbalances, err := s.repository.GetLedgerImbalances()
+	if err != nil {
+		return entity.ReconciliationReport{}, err
+	}
+	for _, imbalance := range imbalances {
+		log.Printf("Reconciliation: %s ledger is off by %s (credits %s, debits %s)",
+			imbalance.Currency, imbalance.Difference, imbalance.Credits, imbalance.Debits)
+	}
+
+	if repair {
+		for i := range drifts {
+			err := s.repairBalance(&drifts[i])
+			if err != nil {
+				return entity.ReconciliationReport{}, err
+			}
+			log.Printf("Reconciliation: account %d drifted by %s, repaired: %t, legs repaired: %d",
+				drifts[i].AccountID, drifts[i].Drift, drifts[i].Repaired, drifts[i].LegsRepaired)
+		}
+	}
+
+	return entity.ReconciliationReport{
+		CheckedAt:       checkedAt,
+		AccountsChecked: accountsChecked,
+		Drifts:          drifts,
+		Imbalances:      imbalances,
+	}, nil
+}
+
+// repairBalance recomputes the ledger balance of a drifted account while holding its balance lock and
+// overwrites the denormalized balance and the balance_after of its legs if they still disagree. The drift may have
+// been reported while a transaction was in flight, in which case there is nothing left to repair.
+func (s *Service) repairBalance(drift *entity.BalanceDrift) error {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return err
+	}
+	defer s.repository.Rollback(tx)
+
+	balance, err := s.repository.GetBalanceWithLock(tx, drift.AccountID)
+	if err != nil {
+		return err
+	}
+
+	ledgerBalance, err := s.repository.GetLedgerBalance(tx, drift.AccountID)
+	if err != nil {
+		return err
+	}
+	if balance.Balance.Equal(ledgerBalance) {
+		return nil
+	}
+
+	err = s.repository.SetBalance(tx, drift.AccountID, ledgerBalance)
+	if err != nil {
+		return err
+	}
+	legsRepaired, err := s.repository.RepairBalancesAfter(tx, drift.AccountID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return err
+	}
+	drift.Repaired = true
+	drift.LegsRepaired = legsRepaired
+	return nil
+}
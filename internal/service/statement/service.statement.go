@@ -0,0 +1,220 @@
+package statement
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+type RepositoryInterface interface {
+	GetBalanceAsOf(accountID int64, asOf time.Time) (entity.AccountBalance, error)
+	StreamTransactionHistory(accountID int64, filter entity.TransactionFilter, fn func(entity.TransactionDetail) error) error
+}
+
+type Service struct {
+	repository RepositoryInterface
+}
+
+func NewService(repo RepositoryInterface) *Service {
+	return &Service{
+		repository: repo,
+	}
+}
+
+// OpenStatement computes the opening balance of an account's statement for the month starting at month
+func (s *Service) OpenStatement(principal entity.Principal, accountID int64, month time.Time) (entity.Statement, error) {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	// Balances as of an instant include it, and timestamps are stored with microsecond precision
+	opening, err := s.repository.GetBalanceAsOf(accountID, from.Add(-time.Microsecond))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.Statement{}, entity.ErrAccountNotFound
+		}
+		return entity.Statement{}, err
+	}
+	if !principal.CanAccess(opening.Owner) {
+		return entity.Statement{}, entity.ErrForbidden
+	}
+
+	return entity.Statement{
+		AccountID:      accountID,
+		Currency:       opening.Currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening.Balance,
+	}, nil
+}
+
+// WriteStatement streams the opening balance, every ledger line of the statement period with the balance after it,
+// and the closing balance to w in the given format
+func (s *Service) WriteStatement(statement entity.Statement, format entity.StatementFormat, w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	var writer statementWriter
+	switch format {
+	case entity.StatementFormatCSV:
+		writer = &csvStatementWriter{writer: csv.NewWriter(buffered)}
+	case entity.StatementFormatJSONL:
+		writer = &jsonlStatementWriter{encoder: json.NewEncoder(buffered)}
+	case entity.StatementFormatText:
+		writer = &textStatementWriter{writer: buffered}
+	default:
+		return fmt.Errorf("unknown statement format %q", format)
+	}
+	scale, _ := entity.CurrencyScale(statement.Currency)
+
+	err := writer.opening(statement, scale)
+	if err != nil {
+		return err
+	}
+
+	balance := statement.OpeningBalance
+	filter := entity.TransactionFilter{From: statement.From, Before: statement.To}
+	err = s.repository.StreamTransactionHistory(statement.AccountID, filter, func(transaction entity.TransactionDetail) error {
+		amount := transaction.Amount
+		if transaction.IsCredit {
+			amount = amount.Neg()
+		}
+		balance = balance.Add(amount)
+		transaction.Type = entity.EntryTypeOf(transaction.TransactionType, transaction.IsCredit)
+		return writer.entry(statement, transaction, amount, balance, scale)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writer.closing(statement, balance, scale)
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// statementWriter renders a statement; amounts are signed, negative when money leaves the account
+type statementWriter interface {
+	opening(statement entity.Statement, scale int32) error
+	entry(statement entity.Statement, transaction entity.TransactionDetail, amount, balance decimal.Decimal, scale int32) error
+	closing(statement entity.Statement, balance decimal.Decimal, scale int32) error
+}
+
+type csvStatementWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvStatementWriter) opening(statement entity.Statement, scale int32) error {
+	err := w.writer.Write([]string{"date", "transaction_id", "ledger_id", "type", "description", "amount", "currency", "balance"})
+	if err != nil {
+		return err
+	}
+	return w.writer.Write([]string{statement.From.Format(time.RFC3339), "", "", "opening_balance", "", "",
+		statement.Currency, statement.OpeningBalance.StringFixed(scale)})
+}
+
+func (w *csvStatementWriter) entry(statement entity.Statement, transaction entity.TransactionDetail, amount, balance decimal.Decimal, scale int32) error {
+	return w.writer.Write([]string{
+		transaction.TransactionDate.UTC().Format(time.RFC3339),
+		fmt.Sprint(transaction.TransactionID),
+		fmt.Sprint(transaction.LedgerID),
+		string(transaction.Type),
+		escapeCSVFormula(transaction.Description),
+		amount.StringFixed(scale),
+		transaction.Currency,
+		balance.StringFixed(scale),
+	})
+}
+
+func (w *csvStatementWriter) closing(statement entity.Statement, balance decimal.Decimal, scale int32) error {
+	err := w.writer.Write([]string{statement.To.Format(time.RFC3339), "", "", "closing_balance", "", "",
+		statement.Currency, balance.StringFixed(scale)})
+	if err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// escapeCSVFormula keeps spreadsheets from evaluating user-provided text as a formula
+func escapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+type jsonlStatementWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlStatementWriter) opening(statement entity.Statement, scale int32) error {
+	return w.encoder.Encode(entity.StatementRecord{
+		Record:   "opening_balance",
+		Date:     statement.From,
+		Currency: statement.Currency,
+		Balance:  statement.OpeningBalance.Round(scale),
+	})
+}
+
+func (w *jsonlStatementWriter) entry(statement entity.Statement, transaction entity.TransactionDetail, amount, balance decimal.Decimal, scale int32) error {
+	amount = amount.Round(scale)
+	return w.encoder.Encode(entity.StatementRecord{
+		Record:        "entry",
+		Date:          transaction.TransactionDate.UTC(),
+		TransactionID: transaction.TransactionID,
+		LedgerID:      transaction.LedgerID,
+		Type:          transaction.Type,
+		Description:   transaction.Description,
+		Amount:        &amount,
+		Currency:      transaction.Currency,
+		Balance:       balance.Round(scale),
+	})
+}
+
+func (w *jsonlStatementWriter) closing(statement entity.Statement, balance decimal.Decimal, scale int32) error {
+	return w.encoder.Encode(entity.StatementRecord{
+		Record:   "closing_balance",
+		Date:     statement.To,
+		Currency: statement.Currency,
+		Balance:  balance.Round(scale),
+	})
+}
+
+type textStatementWriter struct {
+	writer io.Writer
+}
+
+const textStatementLine = "%-20s  %-12s  %-40.40s  %20s  %20s\n"
+
+func (w *textStatementWriter) opening(statement entity.Statement, scale int32) error {
+	_, err := fmt.Fprintf(w.writer, "Statement of account %d (%s), %s\n\n", statement.AccountID, statement.Currency, statement.From.Format("January 2006"))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w.writer, textStatementLine, "Date", "Type", "Description", "Amount", "Balance")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w.writer, textStatementLine, statement.From.Format(time.DateTime), "", "Opening balance", "",
+		statement.OpeningBalance.StringFixed(scale))
+	return err
+}
+
+func (w *textStatementWriter) entry(statement entity.Statement, transaction entity.TransactionDetail, amount, balance decimal.Decimal, scale int32) error {
+	_, err := fmt.Fprintf(w.writer, textStatementLine, transaction.TransactionDate.UTC().Format(time.DateTime), transaction.Type,
+		transaction.Description, amount.StringFixed(scale), balance.StringFixed(scale))
+	return err
+}
+
+func (w *textStatementWriter) closing(statement entity.Statement, balance decimal.Decimal, scale int32) error {
+	_, err := fmt.Fprintf(w.writer, textStatementLine, statement.To.Format(time.DateTime), "", "Closing balance", "",
+		balance.StringFixed(scale))
+	return err
+}
@@ -0,0 +1,113 @@
+package transaction
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// QuoteFee returns the fee a withdrawal or transfer of amount would be charged under the current fee schedule
+func (s *Service) QuoteFee(transactionType entity.TransactionType, currency string, amount decimal.Decimal) (entity.FeeQuoteResponse, error) {
+	if transactionType != entity.TransactionTypeWithdrawal && transactionType != entity.TransactionTypeTransfer {
+		return entity.FeeQuoteResponse{}, entity.ErrInvalidTransactionType
+	}
+	fee, err := s.fee(transactionType, currency, amount)
+	if err != nil {
+		return entity.FeeQuoteResponse{}, err
+	}
+	return entity.FeeQuoteResponse{
+		TransactionType: transactionType,
+		Currency:        currency,
+		Amount:          amount,
+		Fee:             fee,
+		Total:           amount.Add(fee),
+	}, nil
+}
+
+// GetFeeSchedules returns every fee schedule
+func (s *Service) GetFeeSchedules() ([]entity.FeeSchedule, error) {
+	return s.repository.GetFeeSchedules()
+}
+
+// SetFeeSchedule replaces the fee schedule of a transaction type and currency.
+// Transactions booked from then on are charged under the new schedule.
+func (s *Service) SetFeeSchedule(schedule entity.FeeSchedule) (entity.FeeSchedule, error) {
+	if err := schedule.Validate(); err != nil {
+		return entity.FeeSchedule{}, err
+	}
+	err := s.repository.SetFeeSchedule(schedule)
+	if err != nil {
+		return entity.FeeSchedule{}, err
+	}
+	return s.repository.GetFeeSchedule(schedule.TransactionType, schedule.Currency)
+}
+
+// DeleteFeeSchedule stops charging fees on the transactions of a type and currency
+func (s *Service) DeleteFeeSchedule(transactionType entity.TransactionType, currency string) error {
+	return s.repository.DeleteFeeSchedule(transactionType, currency)
+}
+
+// fee computes the fee on a transaction of the given type, zero when no fee schedule applies to it
+func (s *Service) fee(transactionType entity.TransactionType, currency string, amount decimal.Decimal) (decimal.Decimal, error) {
+	schedule, err := s.FeeSchedule(transactionType, currency)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return schedule.Fee(amount), nil
+}
+
+// FeeSchedule returns the fee schedule of a transaction type and currency.
+// Without a schedule it returns the zero schedule, which charges no fee.
+// The hold service uses it with AddFeeLeg to charge captures the withdrawal fee.
+func (s *Service) FeeSchedule(transactionType entity.TransactionType, currency string) (entity.FeeSchedule, error) {
+	schedule, err := s.repository.GetFeeSchedule(transactionType, currency)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.FeeSchedule{}, nil
+		}
+		return entity.FeeSchedule{}, err
+	}
+	return schedule, nil
+}
+
+// feeScheduleKey identifies the fee schedule of a transaction type and currency
+type feeScheduleKey struct {
+	transactionType entity.TransactionType
+	currency        string
+}
+
+// feeScheduleSet holds every fee schedule, loaded once so that all the transactions of an import are charged under the same schedules
+type feeScheduleSet map[feeScheduleKey]entity.FeeSchedule
+
+// feeSchedules loads every fee schedule
+func (s *Service) feeSchedules() (feeScheduleSet, error) {
+	schedules, err := s.repository.GetFeeSchedules()
+	if err != nil {
+		return nil, err
+	}
+	set := make(feeScheduleSet, len(schedules))
+	for _, schedule := range schedules {
+		set[feeScheduleKey{schedule.TransactionType, schedule.Currency}] = schedule
+	}
+	return set, nil
+}
+
+// get returns the fee schedule of a transaction type and currency, the zero schedule charging no fee when there is none
+func (f feeScheduleSet) get(transactionType entity.TransactionType, currency string) entity.FeeSchedule {
+	return f[feeScheduleKey{transactionType, currency}]
+}
+
+// AddFeeLeg appends the leg paying a positive fee into the fee revenue account of the currency, creating the account on first use.
+// Like every system account, the fee revenue account is not locked: it never needs a funds check.
+func (s *Service) AddFeeLeg(tx *sqlx.Tx, legs []entity.LedgerLeg, fee decimal.Decimal, currency string) ([]entity.LedgerLeg, error) {
+	if !fee.IsPositive() {
+		return legs, nil
+	}
+	accountID, err := s.repository.GetSystemAccountID(tx, s.systemAccounts.FeeRevenue, currency)
+	if err != nil {
+		return nil, err
+	}
+	return append(legs, entity.LedgerLeg{AccountID: accountID, Amount: fee, Currency: currency, IsCredit: false}), nil
+}
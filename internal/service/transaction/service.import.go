@@ -0,0 +1,341 @@
+package transaction
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// MaxImportRows bounds the number of data rows of a bulk import file
+const MaxImportRows = 10000
+
+// importColumns lists the columns of a bulk import file; convert is optional
+var importColumns = []string{"type", "account_id", "to_account_id", "amount", "currency", "description"}
+
+// importRow is a parsed row of a bulk import file.
+// Deposits and withdrawals fill accountID and transaction, transfers fill transfer.
+type importRow struct {
+	transactionType entity.TransactionType
+	transaction     entity.CreateTransactionRequest
+	accountID       int64
+	transfer        entity.CreateTransferRequest
+}
+
+// ImportTransactions applies the deposits, withdrawals and transfers of a CSV file with the header
+// type,account_id,to_account_id,amount,currency,description[,convert]. For transfers account_id is the payer.
+// Every row is validated with the rules of the single transaction endpoints before anything is applied.
+// In atomic mode the rows are applied in a single database transaction, all or nothing;
+// otherwise every row is applied on its own and the report tells which ones succeeded.
+func (s *Service) ImportTransactions(principal entity.Principal, file io.Reader, atomic bool) (entity.ImportReport, error) {
+	rows, results, err := parseImportFile(file)
+	if err != nil {
+		return entity.ImportReport{}, err
+	}
+
+	report := entity.ImportReport{Atomic: atomic, Total: len(rows), Rows: results}
+	invalid := false
+	for _, result := range results {
+		if result.Status == entity.ImportRowStatusInvalid {
+			invalid = true
+		}
+	}
+
+	// Fee schedules are loaded once, so a schedule changed during the import does not apply to part of it
+	var schedules feeScheduleSet
+	if !atomic || !invalid {
+		schedules, err = s.feeSchedules()
+		if err != nil {
+			return entity.ImportReport{}, err
+		}
+	}
+
+	switch {
+	case atomic && invalid:
+		markNotApplied(results)
+	case atomic:
+		err = s.importAtomically(principal, rows, results, schedules)
+	default:
+		s.importRowByRow(principal, rows, results, schedules)
+	}
+	if err != nil {
+		return entity.ImportReport{}, err
+	}
+
+	for _, result := range results {
+		if result.Status == entity.ImportRowStatusSuccess {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// importRowByRow applies every valid row in its own database transaction
+func (s *Service) importRowByRow(principal entity.Principal, rows []importRow, results []entity.ImportRowResult, schedules feeScheduleSet) {
+	for i, row := range rows {
+		if results[i].Status == entity.ImportRowStatusInvalid {
+			continue
+		}
+
+		err := s.importRow(principal, row, schedules)
+		results[i] = importResult(results[i].Row, err)
+		if results[i].Status == entity.ImportRowStatusFailed {
+			log.Printf("Error importing row %d: %v", results[i].Row, err)
+		}
+	}
+}
+
+// importRow applies a row in its own database transaction
+func (s *Service) importRow(principal entity.Principal, row importRow, schedules feeScheduleSet) error {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return err
+	}
+	defer s.repository.Rollback(tx)
+
+	err = s.applyImportRow(tx, principal, row, schedules)
+	if err != nil {
+		return err
+	}
+	return s.repository.Commit(tx)
+}
+
+// applyImportRow books a row like the single transaction endpoints, charging fees under the schedules loaded for the import
+func (s *Service) applyImportRow(tx *sqlx.Tx, principal entity.Principal, row importRow, schedules feeScheduleSet) error {
+	var err error
+	switch row.transactionType {
+	case entity.TransactionTypeDeposit:
+		_, err = s.deposit(tx, principal, row.accountID, row.transaction.Amount, row.transaction.Currency, row.transaction.Description, entity.IdempotencyKey{})
+	case entity.TransactionTypeWithdrawal:
+		_, err = s.withdraw(tx, principal, row.accountID, row.transaction.Amount, row.transaction.Currency, row.transaction.Description,
+			schedules.get(entity.TransactionTypeWithdrawal, row.transaction.Currency), entity.IdempotencyKey{})
+	default:
+		_, err = s.transfer(tx, principal, row.transfer.FromAccountID, row.transfer.ToAccountID, row.transfer.Amount, row.transfer.Currency,
+			row.transfer.Convert, row.transfer.Description, schedules.get(entity.TransactionTypeTransfer, row.transfer.Currency), entity.IdempotencyKey{})
+	}
+	return err
+}
+
+// importAtomically applies every row in a single database transaction and rolls all of them back when one fails.
+// Only business errors are reported per row; any other error aborts the import.
+func (s *Service) importAtomically(principal entity.Principal, rows []importRow, results []entity.ImportRowResult, schedules feeScheduleSet) error {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return err
+	}
+	defer s.repository.Rollback(tx)
+
+	err = s.lockImportAccounts(tx, rows)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range rows {
+		err = s.applyImportRow(tx, principal, row, schedules)
+		result := importResult(results[i].Row, err)
+		if result.Status == entity.ImportRowStatusFailed {
+			return err
+		}
+		if result.Status != entity.ImportRowStatusSuccess {
+			markNotApplied(results)
+			results[i] = result
+			return nil
+		}
+		results[i] = result
+	}
+
+	return s.repository.Commit(tx)
+}
+
+// lockImportAccounts locks every customer balance an atomic import touches before applying any row.
+// System accounts have no balance row to lock, their legs are only written to the ledger.
+// Unknown accounts are skipped here and reported by the row that uses them.
+func (s *Service) lockImportAccounts(tx *sqlx.Tx, rows []importRow) error {
+	var customerAccountIDs []int64
+	for _, row := range rows {
+		if row.transactionType == entity.TransactionTypeTransfer {
+			customerAccountIDs = append(customerAccountIDs, row.transfer.FromAccountID, row.transfer.ToAccountID)
+		} else {
+			customerAccountIDs = append(customerAccountIDs, row.accountID)
+		}
+	}
+
+	// Balances are locked in ascending ID order like lockBalances, whatever the order of the rows
+	slices.Sort(customerAccountIDs)
+	for _, accountID := range slices.Compact(customerAccountIDs) {
+		_, err := s.repository.GetBalanceWithLock(tx, accountID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importResult classifies the outcome of applying an import row
+func importResult(row int, err error) entity.ImportRowResult {
+	result := entity.ImportRowResult{Row: row, Status: entity.ImportRowStatusSuccess}
+	switch {
+	case err == nil:
+	case err == sql.ErrNoRows || err == entity.ErrAccountNotFound:
+		result.Status = entity.ImportRowStatusUnknownAccount
+		result.Error = entity.ErrAccountNotFound.Error()
+	case err == entity.ErrInsufficientFunds:
+		result.Status = entity.ImportRowStatusInsufficientFunds
+		result.Error = err.Error()
+	case errors.Is(err, entity.ErrLimitExceeded):
+		result.Status = entity.ImportRowStatusRejected
+		result.Error = err.Error()
+	case err == entity.ErrSystemAccount || err == entity.ErrForbidden || err == entity.ErrAccountFrozen || err == entity.ErrAccountClosed || err == entity.ErrCurrencyMismatch ||
+		err == entity.ErrExchangeRateUnavailable || err == entity.ErrConversionTooSmall:
+		result.Status = entity.ImportRowStatusRejected
+		result.Error = err.Error()
+	default:
+		result.Status = entity.ImportRowStatusFailed
+		result.Error = "failed to process transaction"
+	}
+	return result
+}
+
+// markNotApplied marks every row of a rolled back atomic import as not applied, keeping the rows that failed validation
+func markNotApplied(results []entity.ImportRowResult) {
+	for i := range results {
+		if results[i].Status != entity.ImportRowStatusInvalid {
+			results[i] = entity.ImportRowResult{Row: results[i].Row, Status: entity.ImportRowStatusNotApplied}
+		}
+	}
+}
+
+// parseImportFile reads and validates the rows of a bulk import file.
+// Rows that fail validation are reported as invalid; an unreadable file or header is an error.
+func parseImportFile(file io.Reader) ([]importRow, []entity.ImportRowResult, error) {
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("%w: the file is empty", entity.ErrInvalidImportFile)
+	}
+	if err != nil {
+		return nil, nil, importReadError(err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("%w: missing column %q", entity.ErrInvalidImportFile, name)
+		}
+	}
+
+	var rows []importRow
+	var results []entity.ImportRowResult
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, importReadError(err)
+		}
+		if len(rows) == MaxImportRows {
+			return nil, nil, fmt.Errorf("%w: more than %d rows", entity.ErrInvalidImportFile, MaxImportRows)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row, err := parseImportRow(field)
+		result := entity.ImportRowResult{Row: len(rows) + 1}
+		if err != nil {
+			result.Status = entity.ImportRowStatusInvalid
+			result.Error = err.Error()
+		}
+		rows = append(rows, row)
+		results = append(results, result)
+	}
+
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("%w: no rows to import", entity.ErrInvalidImportFile)
+	}
+	return rows, results, nil
+}
+
+// importReadError reports malformed CSV as an invalid import file and passes read errors through
+func importReadError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%w: %v", entity.ErrInvalidImportFile, err)
+	}
+	return err
+}
+
+// parseImportRow builds the request of an import row and validates it like the single transaction endpoints do
+func parseImportRow(field func(name string) string) (importRow, error) {
+	row := importRow{transactionType: entity.TransactionType(strings.ToLower(field("type")))}
+
+	accountID, err := strconv.ParseInt(field("account_id"), 10, 64)
+	if err != nil {
+		return row, errors.New("invalid account_id")
+	}
+	amount, err := decimal.NewFromString(field("amount"))
+	if err != nil {
+		return row, errors.New("invalid amount")
+	}
+	currency := strings.ToUpper(field("currency"))
+
+	switch row.transactionType {
+	case entity.TransactionTypeDeposit, entity.TransactionTypeWithdrawal:
+		if field("to_account_id") != "" {
+			return row, errors.New("to_account_id is only allowed for transfers")
+		}
+		row.accountID = accountID
+		row.transaction = entity.CreateTransactionRequest{
+			Amount:          amount,
+			Currency:        currency,
+			Description:     field("description"),
+			TransactionType: row.transactionType,
+		}
+		return row, row.transaction.Validate()
+	case entity.TransactionTypeTransfer:
+		toAccountID, err := strconv.ParseInt(field("to_account_id"), 10, 64)
+		if err != nil {
+			return row, errors.New("invalid to_account_id")
+		}
+		convert := false
+		if value := field("convert"); value != "" {
+			convert, err = strconv.ParseBool(value)
+			if err != nil {
+				return row, errors.New("invalid convert, expected true or false")
+			}
+		}
+		row.transfer = entity.CreateTransferRequest{
+			FromAccountID: accountID,
+			ToAccountID:   toAccountID,
+			Amount:        amount,
+			Currency:      currency,
+			Description:   field("description"),
+			Convert:       convert,
+		}
+		return row, row.transfer.Validate()
+	default:
+		return row, errors.New("invalid type, expected deposit, withdrawal or transfer")
+	}
+}
@@ -1,8 +1,15 @@
 package transaction
 
 import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
 	"github.com/jmoiron/sqlx"
 	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+	"github.com/sebastianaldi17/simple-wallet-app/internal/fx"
 	"github.com/shopspring/decimal"
 )
 
@@ -10,87 +17,247 @@ type RepositoryInterface interface {
 	Begin() (*sqlx.Tx, error)
 	Commit(tx *sqlx.Tx) error
 	Rollback(tx *sqlx.Tx) error
-	GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (decimal.Decimal, error)
-	CreateTransaction(trx *sqlx.Tx, accountID int64, amount decimal.Decimal, description string, isCredit bool) error
-	CreateTransfer(trx *sqlx.Tx, fromAccountID, toAccountID int64, amount decimal.Decimal, description string) error
+	GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (entity.AccountBalance, error)
+	CreateTransaction(trx *sqlx.Tx, transaction entity.Transaction, legs []entity.LedgerLeg, idempotencyKey entity.IdempotencyKey) (entity.Transaction, []entity.Ledger, error)
+	GetTransaction(transactionID int64) (entity.Transaction, error)
+	GetLedgers(transactionID int64) ([]entity.Ledger, error)
+	GetReversals(transactionID int64) ([]entity.Transaction, error)
+	GetTransactionWithLock(trx *sqlx.Tx, transactionID int64) (entity.Transaction, error)
+	GetTransactionLedgers(trx *sqlx.Tx, transactionID int64) ([]entity.Ledger, error)
+	GetReversedAmounts(trx *sqlx.Tx, transactionID int64) (decimal.Decimal, map[int64]decimal.Decimal, error)
+	GetSystemAccountID(trx *sqlx.Tx, systemCode, currency string) (int64, error)
+	GetTransactionLimits(trx *sqlx.Tx, accountID int64, currency string) (entity.TransactionLimits, error)
+	GetPaidOut(trx *sqlx.Tx, accountID int64) (decimal.Decimal, error)
+	GetFeeSchedule(transactionType entity.TransactionType, currency string) (entity.FeeSchedule, error)
+	GetFeeSchedules() ([]entity.FeeSchedule, error)
+	SetFeeSchedule(schedule entity.FeeSchedule) error
+	DeleteFeeSchedule(transactionType entity.TransactionType, currency string) error
 	CheckAccountExists(accountID int64) (bool, error)
+	GetIdempotencyRecord(idempotencyKey string) (entity.IdempotencyRecord, error)
+	SaveIdempotentResponse(trx *sqlx.Tx, idempotencyKey string, status int, body []byte) error
+}
+
+// Config holds the settings of the transaction service
+type Config struct {
+	// RateProvider supplies exchange rates for cross-currency transfers
+	RateProvider fx.RateProvider
+	// FXSpread is the fraction of a converted amount kept by the house FX accounts, e.g. 0.005 for 0.5%
+	FXSpread decimal.Decimal
+	// SystemAccounts names the house accounts deposits, withdrawals and conversions are booked against
+	SystemAccounts entity.SystemAccounts
 }
 
 type Service struct {
-	repository RepositoryInterface
+	repository     RepositoryInterface
+	rateProvider   fx.RateProvider
+	fxSpread       decimal.Decimal
+	systemAccounts entity.SystemAccounts
 }
 
-func NewService(repo RepositoryInterface) *Service {
+func NewService(repo RepositoryInterface, config Config) *Service {
 	return &Service{
-		repository: repo,
+		repository:     repo,
+		rateProvider:   config.RateProvider,
+		fxSpread:       config.FXSpread,
+		systemAccounts: config.SystemAccounts,
 	}
 }
 
-func (s *Service) HandleDeposit(accountID int64, amount decimal.Decimal, description string) error {
+func (s *Service) HandleDeposit(principal entity.Principal, accountID int64, amount decimal.Decimal, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
 	tx, err := s.repository.Begin()
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
 	}
 	defer s.repository.Rollback(tx)
 
-	_, err = s.repository.GetBalanceWithLock(tx, accountID)
+	transaction, err := s.deposit(tx, principal, accountID, amount, currency, description, idempotencyKey)
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
 	}
 
-	err = s.repository.CreateTransaction(tx, accountID, amount, description, false)
+	err = s.saveIdempotentResponse(tx, idempotencyKey, transaction)
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	return transaction, nil
+}
+
+func (s *Service) deposit(tx *sqlx.Tx, principal entity.Principal, accountID int64, amount decimal.Decimal, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
+	balance, err := s.repository.GetBalanceWithLock(tx, accountID)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	if !principal.CanAccess(balance.Owner) {
+		return entity.TransactionResponse{}, entity.ErrForbidden
+	}
+
+	if balance.SystemCode.Valid {
+		return entity.TransactionResponse{}, entity.ErrSystemAccount
+	}
+
+	if err := balance.CheckPayIn(); err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	if balance.Currency != currency {
+		return entity.TransactionResponse{}, entity.ErrCurrencyMismatch
 	}
 
-	return s.repository.Commit(tx)
+	// Deposits are booked against the cash-in clearing account of the currency
+	clearingAccountID, err := s.repository.GetSystemAccountID(tx, s.systemAccounts.CashIn, currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	return s.createTransaction(tx, principal, entity.Transaction{
+		Type:        entity.TransactionTypeDeposit,
+		Description: description,
+		Amount:      amount,
+		Currency:    currency,
+	}, []entity.LedgerLeg{
+		{AccountID: clearingAccountID, Amount: amount, Currency: currency, IsCredit: true},
+		{AccountID: accountID, Amount: amount, Currency: currency, IsCredit: false},
+	}, idempotencyKey)
 }
 
-func (s *Service) HandleWithdraw(accountID int64, amount decimal.Decimal, description string) error {
+func (s *Service) HandleWithdraw(principal entity.Principal, accountID int64, amount decimal.Decimal, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
+	schedule, err := s.FeeSchedule(entity.TransactionTypeWithdrawal, currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
 	tx, err := s.repository.Begin()
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
 	}
 	defer s.repository.Rollback(tx)
 
+	transaction, err := s.withdraw(tx, principal, accountID, amount, currency, description, schedule, idempotencyKey)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	err = s.saveIdempotentResponse(tx, idempotencyKey, transaction)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	return transaction, nil
+}
+
+// withdraw books a withdrawal charged under the given fee schedule of withdrawals in the currency
+func (s *Service) withdraw(tx *sqlx.Tx, principal entity.Principal, accountID int64, amount decimal.Decimal, currency, description string, schedule entity.FeeSchedule, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
 	balance, err := s.repository.GetBalanceWithLock(tx, accountID)
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
+	}
+
+	if !principal.CanAccess(balance.Owner) {
+		return entity.TransactionResponse{}, entity.ErrForbidden
 	}
 
-	if balance.LessThan(amount) {
-		return entity.ErrInsufficientFunds
+	if balance.SystemCode.Valid {
+		return entity.TransactionResponse{}, entity.ErrSystemAccount
 	}
 
-	err = s.repository.CreateTransaction(tx, accountID, amount, description, true)
+	if err := balance.CheckPayOut(); err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	if balance.Currency != currency {
+		return entity.TransactionResponse{}, entity.ErrCurrencyMismatch
+	}
+
+	// The fee is debited from the account on top of the amount
+	fee := schedule.Fee(amount)
+	total := amount.Add(fee)
+
+	if err := s.CheckLimits(tx, balance, total); err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	if balance.Available().LessThan(total) {
+		return entity.TransactionResponse{}, entity.ErrInsufficientFunds
+	}
+
+	// Withdrawals are booked against the cash-out clearing account of the currency
+	clearingAccountID, err := s.repository.GetSystemAccountID(tx, s.systemAccounts.CashOut, currency)
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
+	}
+	legs := []entity.LedgerLeg{
+		{AccountID: accountID, Amount: total, Currency: currency, IsCredit: true},
+		{AccountID: clearingAccountID, Amount: amount, Currency: currency, IsCredit: false},
+	}
+	legs, err = s.AddFeeLeg(tx, legs, fee, currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
 	}
 
-	return s.repository.Commit(tx)
+	return s.createTransaction(tx, principal, entity.Transaction{
+		Type:        entity.TransactionTypeWithdrawal,
+		Description: description,
+		Amount:      amount,
+		Currency:    currency,
+		Fee:         fee,
+	}, legs, idempotencyKey)
 }
 
-func (s *Service) HandleTransfer(fromAccountID, toAccountID int64, amount decimal.Decimal, description string) error {
+func (s *Service) HandleTransfer(principal entity.Principal, fromAccountID, toAccountID int64, amount decimal.Decimal, currency string, convert bool, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
+	schedule, err := s.FeeSchedule(entity.TransactionTypeTransfer, currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
 	tx, err := s.repository.Begin()
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
 	}
 	defer s.repository.Rollback(tx)
 
+	transaction, err := s.transfer(tx, principal, fromAccountID, toAccountID, amount, currency, convert, description, schedule, idempotencyKey)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	err = s.saveIdempotentResponse(tx, idempotencyKey, transaction)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	return transaction, nil
+}
+
+// transfer books a transfer charged under the given fee schedule of transfers in the source currency
+func (s *Service) transfer(tx *sqlx.Tx, principal entity.Principal, fromAccountID, toAccountID int64, amount decimal.Decimal, currency string, convert bool, description string, schedule entity.FeeSchedule, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
 	// Verify that both accounts exist
 	fromExists, err := s.repository.CheckAccountExists(fromAccountID)
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
 	}
 	if !fromExists {
-		return entity.ErrAccountNotFound
+		return entity.TransactionResponse{}, entity.ErrAccountNotFound
 	}
 	toExists, err := s.repository.CheckAccountExists(toAccountID)
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
 	}
 	if !toExists {
-		return entity.ErrAccountNotFound
+		return entity.TransactionResponse{}, entity.ErrAccountNotFound
 	}
 
 	// Lock accounts in consistent order (ascending by ID) to prevent deadlocks
@@ -99,29 +266,572 @@ func (s *Service) HandleTransfer(fromAccountID, toAccountID int64, amount decima
 
 	firstBalance, err := s.repository.GetBalanceWithLock(tx, firstLockID)
 	if err != nil {
-		return err
+		return entity.TransactionResponse{}, err
 	}
 
 	secondBalance, err := s.repository.GetBalanceWithLock(tx, secondLockID)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	fromBalance, toBalance := firstBalance, secondBalance
+	if fromAccountID != firstLockID {
+		fromBalance, toBalance = secondBalance, firstBalance
+	}
+
+	// Only the payer's owner may transfer; anyone may be paid
+	if !principal.CanAccess(fromBalance.Owner) {
+		return entity.TransactionResponse{}, entity.ErrForbidden
+	}
+
+	if fromBalance.SystemCode.Valid || toBalance.SystemCode.Valid {
+		return entity.TransactionResponse{}, entity.ErrSystemAccount
+	}
+
+	if err := fromBalance.CheckPayOut(); err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	if err := toBalance.CheckPayIn(); err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	if fromBalance.Currency != currency {
+		return entity.TransactionResponse{}, entity.ErrCurrencyMismatch
+	}
+	// Cross-currency transfers must be explicitly requested by the caller
+	if toBalance.Currency != fromBalance.Currency && !convert {
+		return entity.TransactionResponse{}, entity.ErrCurrencyMismatch
+	}
+
+	// The fee is charged to the payer in the source currency, on top of the amount
+	fee := schedule.Fee(amount)
+	total := amount.Add(fee)
+
+	if err := s.CheckLimits(tx, fromBalance, total); err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	if fromBalance.Available().LessThan(total) {
+		return entity.TransactionResponse{}, entity.ErrInsufficientFunds
+	}
+
+	if toBalance.Currency != fromBalance.Currency {
+		return s.createConversion(tx, principal, fromBalance, toBalance, amount, fee, description, idempotencyKey)
+	}
+	legs := []entity.LedgerLeg{
+		{AccountID: fromAccountID, Amount: total, Currency: currency, IsCredit: true},
+		{AccountID: toAccountID, Amount: amount, Currency: currency, IsCredit: false},
+	}
+	legs, err = s.AddFeeLeg(tx, legs, fee, currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	return s.createTransaction(tx, principal, entity.Transaction{
+		Type:        entity.TransactionTypeTransfer,
+		Description: description,
+		Amount:      amount,
+		Currency:    currency,
+		Fee:         fee,
+	}, legs, idempotencyKey)
+}
+
+// HandleBatchTransfer pays every transfer of a batch from one source account in a single database transaction.
+// All balances are locked up front and the source is checked once against the total of the batch.
+// The idempotency key is recorded on the first transaction of the batch.
+func (s *Service) HandleBatchTransfer(principal entity.Principal, fromAccountID int64, transfers []entity.BatchTransferRequest, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.BatchTransferResponse, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.BatchTransferResponse{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	// Every transfer of the batch is charged its own fee
+	schedule, err := s.FeeSchedule(entity.TransactionTypeTransfer, currency)
+	if err != nil {
+		return entity.BatchTransferResponse{}, err
+	}
+
+	accountIDs := make([]int64, 0, len(transfers)+1)
+	accountIDs = append(accountIDs, fromAccountID)
+	fees := make([]decimal.Decimal, 0, len(transfers))
+	debits := make([]decimal.Decimal, 0, len(transfers))
+	total, totalFee := decimal.Zero, decimal.Zero
+	for _, transfer := range transfers {
+		fee := schedule.Fee(transfer.Amount)
+		accountIDs = append(accountIDs, transfer.ToAccountID)
+		fees = append(fees, fee)
+		debits = append(debits, transfer.Amount.Add(fee))
+		total = total.Add(transfer.Amount)
+		totalFee = totalFee.Add(fee)
+	}
+
+	balances, err := s.lockBalances(tx, accountIDs)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.BatchTransferResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.BatchTransferResponse{}, err
+	}
+
+	if !principal.CanAccess(balances[fromAccountID].Owner) {
+		return entity.BatchTransferResponse{}, entity.ErrForbidden
+	}
+	for _, accountID := range accountIDs {
+		balance := balances[accountID]
+		if balance.SystemCode.Valid {
+			return entity.BatchTransferResponse{}, entity.ErrSystemAccount
+		}
+		if err := balance.CheckLeg(accountID == fromAccountID); err != nil {
+			return entity.BatchTransferResponse{}, err
+		}
+		if balance.Currency != currency {
+			return entity.BatchTransferResponse{}, entity.ErrCurrencyMismatch
+		}
+	}
+	if err := s.CheckLimits(tx, balances[fromAccountID], debits...); err != nil {
+		return entity.BatchTransferResponse{}, err
+	}
+	if balances[fromAccountID].Available().LessThan(total.Add(totalFee)) {
+		return entity.BatchTransferResponse{}, entity.ErrInsufficientFunds
+	}
+
+	response := entity.BatchTransferResponse{
+		FromAccountID: fromAccountID,
+		Currency:      currency,
+		TotalAmount:   total,
+		TotalFee:      totalFee,
+		Transfers:     make([]entity.BatchTransferResult, 0, len(transfers)),
+	}
+	for i, transfer := range transfers {
+		transferDescription := transfer.Description
+		if transferDescription == "" {
+			transferDescription = description
+		}
+		transferIdempotencyKey := entity.IdempotencyKey{}
+		if i == 0 {
+			transferIdempotencyKey = idempotencyKey
+		}
+
+		legs := []entity.LedgerLeg{
+			{AccountID: fromAccountID, Amount: debits[i], Currency: currency, IsCredit: true},
+			{AccountID: transfer.ToAccountID, Amount: transfer.Amount, Currency: currency, IsCredit: false},
+		}
+		legs, err = s.AddFeeLeg(tx, legs, fees[i], currency)
+		if err != nil {
+			return entity.BatchTransferResponse{}, err
+		}
+		transaction, err := s.createTransaction(tx, principal, entity.Transaction{
+			Type:        entity.TransactionTypeTransfer,
+			Description: transferDescription,
+			Amount:      transfer.Amount,
+			Currency:    currency,
+			Fee:         fees[i],
+		}, legs, transferIdempotencyKey)
+		if err != nil {
+			return entity.BatchTransferResponse{}, err
+		}
+		response.Transfers = append(response.Transfers, entity.BatchTransferResult{
+			TransactionID: transaction.TransactionID,
+			ToAccountID:   transfer.ToAccountID,
+			Amount:        transfer.Amount,
+			Fee:           fees[i],
+		})
+	}
+
+	err = s.saveIdempotentResponse(tx, idempotencyKey, response)
+	if err != nil {
+		return entity.BatchTransferResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.BatchTransferResponse{}, err
+	}
+	return response, nil
+}
+
+// GetTransaction returns a transaction with its ledger legs, its reversals and the status they result in.
+// The principal must be able to access one of the accounts of the transaction.
+func (s *Service) GetTransaction(principal entity.Principal, transactionID int64) (entity.TransactionResponse, error) {
+	transaction, err := s.repository.GetTransaction(transactionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.TransactionResponse{}, entity.ErrTransactionNotFound
+		}
+		return entity.TransactionResponse{}, err
+	}
+
+	ledgers, err := s.repository.GetLedgers(transactionID)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	if !canAccessTransaction(principal, ledgers) {
+		return entity.TransactionResponse{}, entity.ErrForbidden
+	}
+	reversals, err := s.repository.GetReversals(transactionID)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	response := entity.NewTransactionResponse(transaction, ledgers)
+	response.AddReversals(reversals)
+	return response, nil
+}
+
+// canAccessTransaction tells whether the principal may access one of the accounts of a transaction
+func canAccessTransaction(principal entity.Principal, ledgers []entity.Ledger) bool {
+	for _, ledger := range ledgers {
+		if principal.CanAccess(ledger.Owner) {
+			return true
+		}
+	}
+	return principal.IsAdmin()
+}
+
+func (s *Service) GetIdempotencyRecord(idempotencyKey string) (entity.IdempotencyRecord, error) {
+	return s.repository.GetIdempotencyRecord(idempotencyKey)
+}
+
+// saveIdempotentResponse stores the response of a request made with an idempotency key on its transaction row.
+// It is saved in the database transaction booking the request, so every committed key has a response to replay.
+// Requests creating transactions are answered with 201 Created, which replays repeat.
+func (s *Service) saveIdempotentResponse(tx *sqlx.Tx, idempotencyKey entity.IdempotencyKey, response interface{}) error {
+	if idempotencyKey.Key == "" {
+		return nil
+	}
+	body, err := json.Marshal(response)
 	if err != nil {
 		return err
 	}
+	return s.repository.SaveIdempotentResponse(tx, idempotencyKey.Key, http.StatusCreated, body)
+}
+
+// HandleReversal books a compensating transaction that mirrors the ledger legs of the original transaction.
+// A zero amount reverses whatever remains of the original; a smaller amount reverses the legs proportionally.
+func (s *Service) HandleReversal(principal entity.Principal, transactionID int64, amount decimal.Decimal, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	// Locking the original transaction serializes concurrent reversals of it
+	original, err := s.repository.GetTransactionWithLock(tx, transactionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.TransactionResponse{}, entity.ErrTransactionNotFound
+		}
+		return entity.TransactionResponse{}, err
+	}
+	if original.ReversalOf.Valid {
+		return entity.TransactionResponse{}, entity.ErrCannotReverseReversal
+	}
+
+	reversedTotal, reversedPerAccount, err := s.repository.GetReversedAmounts(tx, transactionID)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	remaining := original.Amount.Sub(reversedTotal)
+	if amount.IsZero() {
+		amount = remaining
+	}
+	if !amount.IsPositive() || amount.GreaterThan(remaining) {
+		return entity.TransactionResponse{}, entity.ErrReversalExceedsTransaction
+	}
+	if err := entity.ValidateCurrencyAmount(original.Currency, amount); err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	ledgers, err := s.repository.GetTransactionLedgers(tx, transactionID)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	legs := make([]entity.LedgerLeg, 0, len(ledgers))
+	var customerAccountIDs, systemAccountIDs []int64
+	// The fee of the original is refunded along with the amount, in the same proportion
+	fee := decimal.Zero
+	for _, ledger := range ledgers {
+		var legAmount decimal.Decimal
+		if amount.Equal(remaining) {
+			// Reverse exactly what is left so rounding of earlier partial reversals does not accumulate
+			legAmount = ledger.Amount.Sub(reversedPerAccount[ledger.AccountID])
+		} else {
+			scale, ok := entity.CurrencyScale(ledger.Currency)
+			if !ok {
+				return entity.TransactionResponse{}, entity.ErrUnsupportedCurrency
+			}
+			legAmount = ledger.Amount.Mul(amount).Div(original.Amount).Round(scale)
+		}
+		if !legAmount.IsPositive() {
+			continue
+		}
+
+		legs = append(legs, entity.LedgerLeg{
+			AccountID: ledger.AccountID,
+			Amount:    legAmount,
+			Currency:  ledger.Currency,
+			IsCredit:  !ledger.IsCredit,
+		})
+		if ledger.SystemCode.Valid {
+			systemAccountIDs = append(systemAccountIDs, ledger.AccountID)
+			if ledger.SystemCode.String == s.systemAccounts.FeeRevenue {
+				fee = fee.Add(legAmount)
+			}
+		} else {
+			customerAccountIDs = append(customerAccountIDs, ledger.AccountID)
+		}
+	}
+
+	if !amount.Equal(remaining) {
+		rebalanceLegs(legs)
+	}
+
+	balances, err := s.lockBalances(tx, customerAccountIDs)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	for _, leg := range legs {
+		if slices.Contains(systemAccountIDs, leg.AccountID) {
+			continue
+		}
+		// A reversal moves funds in and out of every wallet of the original, so the principal must be able to access each of them
+		if !principal.CanAccess(balances[leg.AccountID].Owner) {
+			return entity.TransactionResponse{}, entity.ErrForbidden
+		}
+		if err := balances[leg.AccountID].CheckLeg(leg.IsCredit); err != nil {
+			return entity.TransactionResponse{}, err
+		}
+		if leg.IsCredit && balances[leg.AccountID].Available().LessThan(leg.Amount) {
+			return entity.TransactionResponse{}, entity.ErrInsufficientFunds
+		}
+	}
+
+	if description == "" {
+		description = fmt.Sprintf("Reversal of transaction %d", transactionID)
+	}
+	transaction, err := s.createTransaction(tx, principal, entity.Transaction{
+		Type:        entity.TransactionTypeReversal,
+		Description: description,
+		Amount:      amount,
+		Currency:    original.Currency,
+		ReversalOf:  sql.NullInt64{Int64: transactionID, Valid: true},
+		Fee:         fee,
+	}, legs, idempotencyKey)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	err = s.saveIdempotentResponse(tx, idempotencyKey, transaction)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	return transaction, nil
+}
+
+// rebalanceLegs absorbs the rounding difference of proportionally scaled legs, per currency,
+// into the largest leg entering an account so the legs balance again
+func rebalanceLegs(legs []entity.LedgerLeg) {
+	differences := make(map[string]decimal.Decimal)
+	largest := make(map[string]int)
+	for i, leg := range legs {
+		if leg.IsCredit {
+			differences[leg.Currency] = differences[leg.Currency].Add(leg.Amount)
+			continue
+		}
+		differences[leg.Currency] = differences[leg.Currency].Sub(leg.Amount)
+		if j, ok := largest[leg.Currency]; !ok || leg.Amount.GreaterThan(legs[j].Amount) {
+			largest[leg.Currency] = i
+		}
+	}
+
+	for currency, difference := range differences {
+		if i, ok := largest[currency]; ok {
+			legs[i].Amount = legs[i].Amount.Add(difference)
+		}
+	}
+}
+
+// HandleMultiLegTransaction books an arbitrary set of balanced legs between customer accounts atomically,
+// e.g. a payment split between a merchant, a fee account and a tax account
+func (s *Service) HandleMultiLegTransaction(principal entity.Principal, legs []entity.LedgerLeg, currency, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	accountIDs := make([]int64, 0, len(legs))
+	total := decimal.Zero
+	for i := range legs {
+		legs[i].Currency = currency
+		accountIDs = append(accountIDs, legs[i].AccountID)
+		if legs[i].IsCredit {
+			total = total.Add(legs[i].Amount)
+		}
+	}
+
+	balances, err := s.lockBalances(tx, accountIDs)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.TransactionResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.TransactionResponse{}, err
+	}
 
-	var fromBalance decimal.Decimal
-	if fromAccountID == firstLockID {
-		fromBalance = firstBalance
-	} else {
-		fromBalance = secondBalance
+	for _, leg := range legs {
+		balance := balances[leg.AccountID]
+		// Funds may only be taken from accounts of the principal
+		if leg.IsCredit && !principal.CanAccess(balance.Owner) {
+			return entity.TransactionResponse{}, entity.ErrForbidden
+		}
+		if balance.SystemCode.Valid {
+			return entity.TransactionResponse{}, entity.ErrSystemAccount
+		}
+		if err := balance.CheckLeg(leg.IsCredit); err != nil {
+			return entity.TransactionResponse{}, err
+		}
+		if balance.Currency != currency {
+			return entity.TransactionResponse{}, entity.ErrCurrencyMismatch
+		}
+		if leg.IsCredit && balance.Available().LessThan(leg.Amount) {
+			return entity.TransactionResponse{}, entity.ErrInsufficientFunds
+		}
+		if leg.IsCredit {
+			if err := s.CheckLimits(tx, balance, leg.Amount); err != nil {
+				return entity.TransactionResponse{}, err
+			}
+		}
 	}
 
-	if fromBalance.LessThan(amount) {
-		return entity.ErrInsufficientFunds
+	transaction, err := s.createTransaction(tx, principal, entity.Transaction{
+		Type:        entity.TransactionTypeTransfer,
+		Description: description,
+		Amount:      total,
+		Currency:    currency,
+	}, legs, idempotencyKey)
+	if err != nil {
+		return entity.TransactionResponse{}, err
 	}
 
-	err = s.repository.CreateTransfer(tx, fromAccountID, toAccountID, amount, description)
+	err = s.saveIdempotentResponse(tx, idempotencyKey, transaction)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	return transaction, nil
+}
+
+// CheckLimits checks the payouts of an account against its transaction limits, each payout counting against the daily limit of the next.
+// Limits cap the gross payout: each amount is everything debited from the account, fees included, which is also what GetPaidOut sums.
+// The caller must hold the lock of the account's balance, so concurrent payouts cannot both fit under the daily limit.
+// The hold service checks captures with it too.
+func (s *Service) CheckLimits(tx *sqlx.Tx, balance entity.AccountBalance, amounts ...decimal.Decimal) error {
+	limits, err := s.repository.GetTransactionLimits(tx, balance.AccountID, balance.Currency)
 	if err != nil {
 		return err
 	}
+	if !limits.PerTransaction.Valid && !limits.Daily.Valid {
+		return nil
+	}
 
-	return s.repository.Commit(tx)
+	paidOut, err := s.repository.GetPaidOut(tx, balance.AccountID)
+	if err != nil {
+		return err
+	}
+	for _, amount := range amounts {
+		if err := limits.CheckPayOut(balance.Currency, amount, paidOut); err != nil {
+			return err
+		}
+		paidOut = paidOut.Add(amount)
+	}
+	return nil
+}
+
+// lockBalances locks the balances of customer accounts in ascending ID order, which prevents deadlocks between transactions.
+// System accounts are never locked, their legs are only written to the ledger.
+func (s *Service) lockBalances(tx *sqlx.Tx, accountIDs []int64) (map[int64]entity.AccountBalance, error) {
+	balances := make(map[int64]entity.AccountBalance, len(accountIDs))
+	sorted := slices.Clone(accountIDs)
+	slices.Sort(sorted)
+	for _, accountID := range slices.Compact(sorted) {
+		balance, err := s.repository.GetBalanceWithLock(tx, accountID)
+		if err != nil {
+			return nil, err
+		}
+		balances[accountID] = balance
+	}
+	return balances, nil
+}
+
+// createConversion converts amount from the source to the destination currency and books it through the house FX accounts.
+// The customer receives the mid-market rate net of the spread, which stays with the house.
+// The fee, in the source currency, is debited from the payer on top of the converted amount.
+func (s *Service) createConversion(tx *sqlx.Tx, principal entity.Principal, from, to entity.AccountBalance, amount, fee decimal.Decimal, description string, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
+	midRate, err := s.rateProvider.GetRate(from.Currency, to.Currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	appliedRate := midRate.Mul(decimal.NewFromInt(1).Sub(s.fxSpread)).Round(18)
+
+	scale, ok := entity.CurrencyScale(to.Currency)
+	if !ok {
+		return entity.TransactionResponse{}, entity.ErrUnsupportedCurrency
+	}
+	convertedAmount := amount.Mul(appliedRate).RoundFloor(scale)
+	if !convertedAmount.IsPositive() {
+		return entity.TransactionResponse{}, entity.ErrConversionTooSmall
+	}
+
+	fromHouseAccountID, err := s.repository.GetSystemAccountID(tx, s.systemAccounts.FXHouse, from.Currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	toHouseAccountID, err := s.repository.GetSystemAccountID(tx, s.systemAccounts.FXHouse, to.Currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	legs := []entity.LedgerLeg{
+		{AccountID: from.AccountID, Amount: amount.Add(fee), Currency: from.Currency, IsCredit: true},
+		{AccountID: fromHouseAccountID, Amount: amount, Currency: from.Currency, IsCredit: false},
+		{AccountID: toHouseAccountID, Amount: convertedAmount, Currency: to.Currency, IsCredit: true},
+		{AccountID: to.AccountID, Amount: convertedAmount, Currency: to.Currency, IsCredit: false},
+	}
+	legs, err = s.AddFeeLeg(tx, legs, fee, from.Currency)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+
+	return s.createTransaction(tx, principal, entity.Transaction{
+		Type:        entity.TransactionTypeTransfer,
+		Description: description,
+		Amount:      amount,
+		Currency:    from.Currency,
+		FXRate:      decimal.NewNullDecimal(appliedRate),
+		Fee:         fee,
+	}, legs, idempotencyKey)
+}
+
+// createTransaction books a transaction on behalf of the principal after checking that its legs balance per currency.
+// The repository checks the written ledger again before the transaction can be committed.
+func (s *Service) createTransaction(tx *sqlx.Tx, principal entity.Principal, transaction entity.Transaction, legs []entity.LedgerLeg, idempotencyKey entity.IdempotencyKey) (entity.TransactionResponse, error) {
+	if err := entity.ValidateLedgerLegs(legs); err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	transaction.CreatedBy = principal.Subject
+	transaction, ledgers, err := s.repository.CreateTransaction(tx, transaction, legs, idempotencyKey)
+	if err != nil {
+		return entity.TransactionResponse{}, err
+	}
+	return entity.NewTransactionResponse(transaction, ledgers), nil
 }
@@ -0,0 +1,110 @@
+package wallet
+
+import (
+	"database/sql"
+
+	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
+)
+
+// GetWalletLimits returns the transaction limits of an account along with what it paid out over the last 24 hours
+func (s *Service) GetWalletLimits(principal entity.Principal, accountID int64) (entity.WalletLimitsResponse, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.WalletLimitsResponse{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	balance, err := s.repository.GetBalance(accountID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.WalletLimitsResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.WalletLimitsResponse{}, err
+	}
+	if !principal.CanAccess(balance.Owner) {
+		return entity.WalletLimitsResponse{}, entity.ErrForbidden
+	}
+	if balance.SystemCode.Valid {
+		return entity.WalletLimitsResponse{}, entity.ErrSystemAccount
+	}
+
+	overrides, err := s.repository.GetAccountLimits(tx, accountID)
+	if err != nil {
+		return entity.WalletLimitsResponse{}, err
+	}
+	effective, err := s.repository.GetTransactionLimits(tx, accountID, balance.Currency)
+	if err != nil {
+		return entity.WalletLimitsResponse{}, err
+	}
+	paidOut, err := s.repository.GetPaidOut(tx, accountID)
+	if err != nil {
+		return entity.WalletLimitsResponse{}, err
+	}
+	return entity.WalletLimitsResponse{
+		AccountID: accountID,
+		Currency:  balance.Currency,
+		Overrides: overrides,
+		Effective: effective,
+		PaidOut:   paidOut,
+		Remaining: effective.Allowance(paidOut),
+	}, nil
+}
+
+// SetWalletLimits replaces the transaction limits set on an account.
+// The account's balance is locked so the change does not interleave with a payout checking the limits.
+func (s *Service) SetWalletLimits(principal entity.Principal, accountID int64, request entity.SetLimitsRequest) (entity.WalletLimitsResponse, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.WalletLimitsResponse{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	balance, err := s.repository.GetBalanceWithLock(tx, accountID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.WalletLimitsResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.WalletLimitsResponse{}, err
+	}
+	if balance.SystemCode.Valid {
+		return entity.WalletLimitsResponse{}, entity.ErrSystemAccount
+	}
+	if err := request.Validate(balance.Currency); err != nil {
+		return entity.WalletLimitsResponse{}, err
+	}
+
+	err = s.repository.SetAccountLimits(tx, accountID, request.Limits())
+	if err != nil {
+		return entity.WalletLimitsResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.WalletLimitsResponse{}, err
+	}
+	return s.GetWalletLimits(principal, accountID)
+}
+
+// GetCurrencyLimits returns the default transaction limits of a currency
+func (s *Service) GetCurrencyLimits(currency string) (entity.CurrencyLimitsResponse, error) {
+	if _, ok := entity.CurrencyScale(currency); !ok {
+		return entity.CurrencyLimitsResponse{}, entity.ErrUnsupportedCurrency
+	}
+	limits, err := s.repository.GetCurrencyLimits(currency)
+	if err != nil {
+		return entity.CurrencyLimitsResponse{}, err
+	}
+	return entity.CurrencyLimitsResponse{Currency: currency, TransactionLimits: limits}, nil
+}
+
+// SetCurrencyLimits replaces the default transaction limits of a currency, applied to accounts without limits of their own
+func (s *Service) SetCurrencyLimits(currency string, request entity.SetLimitsRequest) (entity.CurrencyLimitsResponse, error) {
+	if err := request.Validate(currency); err != nil {
+		return entity.CurrencyLimitsResponse{}, err
+	}
+	err := s.repository.SetCurrencyLimits(currency, request.Limits())
+	if err != nil {
+		return entity.CurrencyLimitsResponse{}, err
+	}
+	return s.GetCurrencyLimits(currency)
+}
@@ -1,7 +1,11 @@
 package wallet
 
 import (
+	"database/sql"
+	"time"
+
 	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
 	"github.com/sebastianaldi17/simple-wallet-app/internal/entity"
 	"github.com/shopspring/decimal"
 )
@@ -10,10 +14,23 @@ type RepositoryInterface interface {
 	Begin() (*sqlx.Tx, error)
 	Commit(tx *sqlx.Tx) error
 	Rollback(tx *sqlx.Tx) error
-	GetBalance(accountID int64) (decimal.Decimal, error)
-	CreateAccount(trx *sqlx.Tx, accountName string) (int64, error)
-	CheckAccountExists(accountID int64) (bool, error)
-	GetTransactionHistory(accountID int64, startDate, endDate string) ([]entity.TransactionDetail, error)
+	GetBalance(accountID int64) (entity.AccountBalance, error)
+	GetAccount(accountID int64) (entity.Account, error)
+	GetAccountByExternalRef(externalRef string) (entity.Account, error)
+	UpdateAccount(trx *sqlx.Tx, accountID int64, request entity.UpdateAccountRequest) error
+	GetBalanceWithLock(trx *sqlx.Tx, accountID int64) (entity.AccountBalance, error)
+	UpdateAccountStatus(trx *sqlx.Tx, accountID int64, from, to entity.AccountStatus, blockCredits bool, reason, changedBy string) error
+	GetBalanceAsOf(accountID int64, asOf time.Time) (entity.AccountBalance, error)
+	CreateBalanceSnapshots(snapshotAt time.Time) (int64, error)
+	CreateAccount(trx *sqlx.Tx, account entity.Account) (int64, error)
+	GetTransactionHistory(accountID int64, filter entity.TransactionFilter, page entity.HistoryPage) ([]entity.TransactionDetail, error)
+	GetCounterparties(accountID int64, transactionIDs []int64) ([]entity.Counterparty, error)
+	GetTransactionLimits(trx *sqlx.Tx, accountID int64, currency string) (entity.TransactionLimits, error)
+	GetAccountLimits(trx *sqlx.Tx, accountID int64) (entity.TransactionLimits, error)
+	GetPaidOut(trx *sqlx.Tx, accountID int64) (decimal.Decimal, error)
+	SetAccountLimits(trx *sqlx.Tx, accountID int64, limits entity.TransactionLimits) error
+	GetCurrencyLimits(currency string) (entity.TransactionLimits, error)
+	SetCurrencyLimits(currency string, limits entity.TransactionLimits) error
 }
 
 type Service struct {
@@ -26,55 +43,255 @@ func NewService(repo RepositoryInterface) *Service {
 	}
 }
 
-func (s *Service) GetBalance(accountID int64) (entity.GetBalanceResponse, error) {
-	balance, err := s.repository.GetBalance(accountID)
+// GetWallet returns the profile of an account along with its balance
+func (s *Service) GetWallet(principal entity.Principal, accountID int64) (entity.WalletResponse, error) {
+	account, err := s.repository.GetAccount(accountID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.WalletResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.WalletResponse{}, err
+	}
+	if !principal.CanAccess(account.Owner) {
+		return entity.WalletResponse{}, entity.ErrForbidden
+	}
+	return entity.NewWalletResponse(account), nil
+}
+
+// GetWalletByExternalRef looks an account up by its external reference.
+// Accounts of other principals are reported as not found so references cannot be probed.
+func (s *Service) GetWalletByExternalRef(principal entity.Principal, externalRef string) (entity.WalletResponse, error) {
+	account, err := s.repository.GetAccountByExternalRef(externalRef)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.WalletResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.WalletResponse{}, err
+	}
+	if !principal.CanAccess(account.Owner) {
+		return entity.WalletResponse{}, entity.ErrAccountNotFound
+	}
+	return entity.NewWalletResponse(account), nil
+}
+
+// UpdateWallet updates the profile of a customer account; closed accounts can no longer be changed
+func (s *Service) UpdateWallet(principal entity.Principal, accountID int64, request entity.UpdateAccountRequest) (entity.WalletResponse, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.WalletResponse{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	balance, err := s.repository.GetBalanceWithLock(tx, accountID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.WalletResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.WalletResponse{}, err
+	}
+	if !principal.CanAccess(balance.Owner) {
+		return entity.WalletResponse{}, entity.ErrForbidden
+	}
+	if balance.SystemCode.Valid {
+		return entity.WalletResponse{}, entity.ErrSystemAccount
+	}
+	if balance.Status == entity.AccountStatusClosed {
+		return entity.WalletResponse{}, entity.ErrAccountClosed
+	}
+
+	err = s.repository.UpdateAccount(tx, accountID, request)
+	if err != nil {
+		return entity.WalletResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.WalletResponse{}, err
+	}
+	return s.GetWallet(principal, accountID)
+}
+
+// FreezeAccount blocks payouts from an account, and payments into it when blockCredits is set.
+// Freezing a frozen account updates whether credits are blocked.
+func (s *Service) FreezeAccount(principal entity.Principal, accountID int64, blockCredits bool, reason string) (entity.AccountStatusResponse, error) {
+	return s.changeAccountStatus(principal, accountID, entity.AccountStatusFrozen, blockCredits, reason)
+}
+
+// UnfreezeAccount lifts the freeze of an account
+func (s *Service) UnfreezeAccount(principal entity.Principal, accountID int64, reason string) (entity.AccountStatusResponse, error) {
+	return s.changeAccountStatus(principal, accountID, entity.AccountStatusActive, false, reason)
+}
+
+// CloseAccount closes an account for good; its balance must be zero and it must not have active holds
+func (s *Service) CloseAccount(principal entity.Principal, accountID int64, reason string) (entity.AccountStatusResponse, error) {
+	return s.changeAccountStatus(principal, accountID, entity.AccountStatusClosed, false, reason)
+}
+
+// changeAccountStatus applies a status transition under the account's balance lock,
+// so it cannot interleave with a transaction checking the status. The principal is recorded with the change.
+func (s *Service) changeAccountStatus(principal entity.Principal, accountID int64, to entity.AccountStatus, blockCredits bool, reason string) (entity.AccountStatusResponse, error) {
+	tx, err := s.repository.Begin()
+	if err != nil {
+		return entity.AccountStatusResponse{}, err
+	}
+	defer s.repository.Rollback(tx)
+
+	balance, err := s.repository.GetBalanceWithLock(tx, accountID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.AccountStatusResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.AccountStatusResponse{}, err
+	}
+	if balance.SystemCode.Valid {
+		return entity.AccountStatusResponse{}, entity.ErrSystemAccount
+	}
+
+	switch {
+	case balance.Status == entity.AccountStatusClosed:
+		return entity.AccountStatusResponse{}, entity.ErrAccountClosed
+	case to == entity.AccountStatusActive && balance.Status != entity.AccountStatusFrozen:
+		return entity.AccountStatusResponse{}, entity.ErrInvalidStatusTransition
+	case to == entity.AccountStatusClosed && (!balance.Balance.IsZero() || !balance.HeldAmount.IsZero()):
+		return entity.AccountStatusResponse{}, entity.ErrAccountNotEmpty
+	}
+
+	err = s.repository.UpdateAccountStatus(tx, accountID, balance.Status, to, blockCredits, reason, principal.Subject)
+	if err != nil {
+		return entity.AccountStatusResponse{}, err
+	}
+
+	err = s.repository.Commit(tx)
+	if err != nil {
+		return entity.AccountStatusResponse{}, err
+	}
+	return entity.AccountStatusResponse{
+		AccountID:    accountID,
+		Status:       to,
+		BlockCredits: blockCredits,
+	}, nil
+}
+
+// GetBalanceAsOf derives the ledger balance of an account at the given instant
+func (s *Service) GetBalanceAsOf(principal entity.Principal, accountID int64, asOf time.Time) (entity.GetBalanceAsOfResponse, error) {
+	balance, err := s.repository.GetBalanceAsOf(accountID, asOf)
 	if err != nil {
-		return entity.GetBalanceResponse{}, err
+		if err == sql.ErrNoRows {
+			return entity.GetBalanceAsOfResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.GetBalanceAsOfResponse{}, err
+	}
+	if !principal.CanAccess(balance.Owner) {
+		return entity.GetBalanceAsOfResponse{}, entity.ErrForbidden
 	}
-	return entity.GetBalanceResponse{
+	return entity.GetBalanceAsOfResponse{
 		AccountID: accountID,
-		Balance:   balance,
+		Currency:  balance.Currency,
+		Balance:   balance.Balance,
+		AsOf:      asOf,
 	}, nil
 }
 
-func (s *Service) CreateAccount(request entity.CreateAccountRequest) (entity.CreateAccountResponse, error) {
+// CreateBalanceSnapshots records the balance of every account at the given instant
+// and returns the number of snapshots written
+func (s *Service) CreateBalanceSnapshots(snapshotAt time.Time) (int64, error) {
+	return s.repository.CreateBalanceSnapshots(snapshotAt)
+}
+
+// CreateAccount opens an account owned by the principal
+func (s *Service) CreateAccount(principal entity.Principal, request entity.CreateAccountRequest) (entity.WalletResponse, error) {
 	tx, err := s.repository.Begin()
 	if err != nil {
-		return entity.CreateAccountResponse{}, err
+		return entity.WalletResponse{}, err
 	}
 	defer s.repository.Rollback(tx)
 
-	accountID, err := s.repository.CreateAccount(tx, request.Name)
+	account := entity.Account{
+		Name:        request.Name,
+		ExternalRef: sql.NullString{String: request.ExternalRef, Valid: request.ExternalRef != ""},
+		Metadata:    types.JSONText("{}"),
+	}
+	account.Currency = request.Currency
+	account.Owner = sql.NullString{String: principal.Subject, Valid: true}
+	if request.Metadata != nil {
+		account.Metadata = types.JSONText(request.Metadata)
+	}
+	accountID, err := s.repository.CreateAccount(tx, account)
 	if err != nil {
-		return entity.CreateAccountResponse{}, err
+		return entity.WalletResponse{}, err
 	}
 
 	err = s.repository.Commit(tx)
 	if err != nil {
-		return entity.CreateAccountResponse{}, err
+		return entity.WalletResponse{}, err
 	}
-	return entity.CreateAccountResponse{
-		AccountID:   accountID,
-		AccountName: request.Name,
-	}, nil
+	return s.GetWallet(principal, accountID)
 }
 
-func (s *Service) GetTransactionHistory(accountID int64, startDate, endDate string) (entity.TransactionListResponse, error) {
-	exists, err := s.repository.CheckAccountExists(accountID) // Ensure the account exists before fetching transaction history
+func (s *Service) GetTransactionHistory(principal entity.Principal, accountID int64, filter entity.TransactionFilter, page entity.HistoryPage) (entity.TransactionListResponse, error) {
+	balance, err := s.repository.GetBalance(accountID) // Ensure the account exists and may be accessed before fetching transaction history
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return entity.TransactionListResponse{}, entity.ErrAccountNotFound
+		}
+		return entity.TransactionListResponse{}, err
+	}
+	if !principal.CanAccess(balance.Owner) {
+		return entity.TransactionListResponse{}, entity.ErrForbidden
+	}
+	transactions, err := s.repository.GetTransactionHistory(accountID, filter, page)
 	if err != nil {
 		return entity.TransactionListResponse{}, err
 	}
-	if !exists {
-		return entity.TransactionListResponse{}, entity.ErrAccountNotFound
+
+	// The repository fetches one extra row to tell whether another page follows
+	var nextCursor string
+	if len(transactions) > page.Limit {
+		transactions = transactions[:page.Limit]
+		last := transactions[len(transactions)-1]
+		nextCursor = entity.EncodeHistoryCursor(entity.HistoryCursor{
+			TransactionDate: last.TransactionDate,
+			LedgerID:        last.LedgerID,
+		})
 	}
-	transactions, err := s.repository.GetTransactionHistory(accountID, startDate, endDate)
+
+	err = s.describeEntries(accountID, transactions)
 	if err != nil {
 		return entity.TransactionListResponse{}, err
 	}
+
 	return entity.TransactionListResponse{
 		AccountID:    accountID,
 		Transactions: transactions,
-		StartDate:    startDate,
-		EndDate:      endDate,
+		NextCursor:   nextCursor,
 	}, nil
 }
+
+// describeEntries fills in the entry type and the counterparties of the account's history entries
+func (s *Service) describeEntries(accountID int64, transactions []entity.TransactionDetail) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	transactionIDs := make([]int64, 0, len(transactions))
+	for _, transaction := range transactions {
+		transactionIDs = append(transactionIDs, int64(transaction.TransactionID))
+	}
+	counterparties, err := s.repository.GetCounterparties(accountID, transactionIDs)
+	if err != nil {
+		return err
+	}
+	byTransaction := make(map[int][]entity.Counterparty, len(transactions))
+	for _, counterparty := range counterparties {
+		byTransaction[counterparty.TransactionID] = append(byTransaction[counterparty.TransactionID], counterparty)
+	}
+
+	for i := range transactions {
+		transactions[i].Type = entity.EntryTypeOf(transactions[i].TransactionType, transactions[i].IsCredit)
+		transactions[i].Counterparties = byTransaction[transactions[i].TransactionID]
+		if transactions[i].Counterparties == nil {
+			transactions[i].Counterparties = []entity.Counterparty{}
+		}
+	}
+	return nil
+}